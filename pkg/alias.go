@@ -0,0 +1,41 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+var aliasPlaceholderRegexp = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+
+// applyAlias names the numeric fields of the frames after the alias setting.
+// Its placeholders are {{metric}} for the field name, {{refId}} for the query
+// reference id and {{label:x}}, or simply {{x}}, for the value of the x label,
+// i.e. of the x dimension. Unknown labels expand to an empty string.
+func (ds *druidDatasource) applyAlias(frames []*data.Frame, alias string, refID string) {
+	if alias == "" {
+		return
+	}
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			if !field.Type().Numeric() {
+				continue
+			}
+			name := aliasPlaceholderRegexp.ReplaceAllStringFunc(alias, func(m string) string {
+				placeholder := aliasPlaceholderRegexp.FindStringSubmatch(m)[1]
+				switch placeholder {
+				case "metric":
+					return field.Name
+				case "refId":
+					return refID
+				}
+				return field.Labels[strings.TrimPrefix(placeholder, "label:")]
+			})
+			if field.Config == nil {
+				field.Config = &data.FieldConfig{}
+			}
+			field.Config.DisplayNameFromDS = name
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitly/go-simplejson"
+)
+
+// tokenExpiryMargin is how long before its expiry an OAuth2 token is renewed,
+// so that it does not expire while a query is in flight.
+const tokenExpiryMargin = 30 * time.Second
+
+// authMode returns the authentication scheme of the connection: basic, bearer
+// or oauth2, or none. Datasources saved before the mode selector existed only
+// have the basicAuth toggle.
+func authMode(data *simplejson.Json) string {
+	if mode := data.Get("connection.authMode").MustString(); mode != "" {
+		return mode
+	}
+	if data.Get("connection.basicAuth").MustBool() {
+		return "basic"
+	}
+	return "none"
+}
+
+// tokenSource provides the bearer token requests are authenticated with.
+type tokenSource interface {
+	token(ctx context.Context) (string, error)
+	// invalidate drops a token the server rejected.
+	invalidate(token string)
+}
+
+// newTokenSource returns the token source of the bearer and oauth2 modes, and
+// nil for the other ones. OAuth2 tokens are fetched with client.
+func newTokenSource(data *simplejson.Json, secureData map[string]string, client *http.Client) (tokenSource, error) {
+	switch authMode(data) {
+	case "bearer":
+		t := secureData["connection.bearerToken"]
+		if t == "" {
+			return nil, errors.New("bearer authentication needs a token")
+		}
+		return staticToken(t), nil
+	case "oauth2":
+		s := &clientCredentialsTokenSource{
+			client:       client,
+			tokenURL:     data.Get("connection.oauth2TokenUrl").MustString(),
+			clientID:     data.Get("connection.oauth2ClientId").MustString(),
+			clientSecret: secureData["connection.oauth2ClientSecret"],
+			scopes: strings.FieldsFunc(data.Get("connection.oauth2Scopes").MustString(), func(r rune) bool {
+				return r == ',' || r == ' '
+			}),
+		}
+		if s.tokenURL == "" || s.clientID == "" {
+			return nil, errors.New("oauth2 authentication needs a token URL and a client ID")
+		}
+		return s, nil
+	}
+	return nil, nil
+}
+
+type staticToken string
+
+func (t staticToken) token(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+func (t staticToken) invalidate(token string) {}
+
+// clientCredentialsTokenSource gets tokens with the OAuth2 client credentials
+// grant and reuses them until they are about to expire.
+type clientCredentialsTokenSource struct {
+	client       *http.Client
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+
+	mu          sync.Mutex
+	accessToken string
+	expiry      time.Time
+}
+
+func (s *clientCredentialsTokenSource) token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accessToken != "" && (s.expiry.IsZero() || time.Now().Add(tokenExpiryMargin).Before(s.expiry)) {
+		return s.accessToken, nil
+	}
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.scopes) > 0 {
+		form.Set("scope", strings.Join(s.scopes, " "))
+	}
+	req, err := http.NewRequest(http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oauth2 token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("oauth2 token request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oauth2 token request failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var t struct {
+		AccessToken string  `json:"access_token"`
+		ExpiresIn   float64 `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &t); err != nil {
+		return "", fmt.Errorf("invalid oauth2 token response: %w", err)
+	}
+	if t.AccessToken == "" {
+		return "", errors.New("invalid oauth2 token response: no access token")
+	}
+	s.accessToken = t.AccessToken
+	s.expiry = time.Time{}
+	if t.ExpiresIn > 0 {
+		s.expiry = time.Now().Add(time.Duration(t.ExpiresIn) * time.Second)
+	}
+	return s.accessToken, nil
+}
+
+func (s *clientCredentialsTokenSource) invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accessToken == token {
+		s.accessToken = ""
+	}
+}
+
+// authTransport sets the bearer token on every request sent to Druid.
+type authTransport struct {
+	base   http.RoundTripper
+	tokens tokenSource
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" {
+		//note: a forwarded user token takes precedence
+		return t.base.RoundTrip(req)
+	}
+	token, err := t.tokens.token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	resp, err := t.base.RoundTrip(r)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		//note: 401 responses are not retried, the next query fetches a new token
+		t.tokens.invalidate(token)
+	}
+	return resp, err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeTokenServer issues the tokens token-1, token-2... valid for expiresIn
+// seconds with the client credentials grant.
+type fakeTokenServer struct {
+	mu        sync.Mutex
+	issued    int
+	expiresIn int
+}
+
+func (s *fakeTokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+		http.Error(w, "bad grant", http.StatusBadRequest)
+		return
+	}
+	if id, secret, ok := r.BasicAuth(); !ok || id != "id" || secret != "secret" {
+		http.Error(w, "bad client", http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	s.issued++
+	issued := s.issued
+	s.mu.Unlock()
+	fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d}`, issued, s.expiresIn)
+}
+
+func (s *fakeTokenServer) issuedTokens() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+func newTestTokenSource(url string) *clientCredentialsTokenSource {
+	return &clientCredentialsTokenSource{client: http.DefaultClient, tokenURL: url, clientID: "id", clientSecret: "secret"}
+}
+
+func TestClientCredentialsTokenSource(t *testing.T) {
+	tokens := &fakeTokenServer{expiresIn: 3600}
+	srv := httptest.NewServer(tokens)
+	defer srv.Close()
+
+	s := newTestTokenSource(srv.URL)
+	for i := 0; i < 2; i++ {
+		if token, err := s.token(context.Background()); err != nil || token != "token-1" {
+			t.Errorf("call %d: token = %s, %v, want the cached token-1", i, token, err)
+		}
+	}
+
+	//note: tokens expiring within tokenExpiryMargin are renewed on every call
+	shortSrv := httptest.NewServer(&fakeTokenServer{expiresIn: int(tokenExpiryMargin.Seconds()) - 1})
+	defer shortSrv.Close()
+	s = newTestTokenSource(shortSrv.URL)
+	for _, want := range []string{"token-1", "token-2"} {
+		if token, err := s.token(context.Background()); err != nil || token != want {
+			t.Errorf("token = %s, %v, want %s", token, err, want)
+		}
+	}
+
+	//note: an invalidated token is fetched again
+	s = newTestTokenSource(srv.URL)
+	if token, err := s.token(context.Background()); err != nil || token != "token-2" {
+		t.Fatalf("token = %s, %v, want token-2", token, err)
+	}
+	s.invalidate("token-1")
+	if token, err := s.token(context.Background()); err != nil || token != "token-2" {
+		t.Errorf("token = %s, %v, want token-2 kept when another one is invalidated", token, err)
+	}
+	s.invalidate("token-2")
+	if token, err := s.token(context.Background()); err != nil || token != "token-3" {
+		t.Errorf("token = %s, %v, want token-3", token, err)
+	}
+}
+
+func TestAuthTransportInvalidation(t *testing.T) {
+	tokens := &fakeTokenServer{expiresIn: 3600}
+	tokenSrv := httptest.NewServer(tokens)
+	defer tokenSrv.Close()
+	//note: the broker revoked token-1
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, r.Header.Get("Authorization"))
+	}))
+	defer broker.Close()
+
+	client := &http.Client{Transport: &authTransport{base: http.DefaultTransport, tokens: newTestTokenSource(tokenSrv.URL)}}
+	for _, want := range []int{http.StatusUnauthorized, http.StatusOK, http.StatusOK} {
+		resp, err := client.Get(broker.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("status = %d, want %d", resp.StatusCode, want)
+		}
+	}
+	if issued := tokens.issuedTokens(); issued != 2 {
+		t.Errorf("issued %d tokens, want 2: the rejected one and its cached replacement", issued)
+	}
+
+	//note: forwarded user tokens are sent as they are
+	req, _ := http.NewRequest(http.MethodGet, broker.URL, nil)
+	req.Header.Set("Authorization", "Bearer user")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if issued := tokens.issuedTokens(); resp.StatusCode != http.StatusOK || issued != 2 {
+		t.Errorf("forwarded token: status = %d, issued %d tokens, want 200 and 2", resp.StatusCode, issued)
+	}
+}
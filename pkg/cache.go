@@ -0,0 +1,73 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// queryCache is a short life LRU cache of raw Druid responses keyed by the
+// prepared Druid query. Its memory footprint is bounded by maxBytes.
+type queryCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	maxBytes int
+	bytes    int
+	entries  *list.List
+	items    map[string]*list.Element
+}
+
+type queryCacheEntry struct {
+	key     string
+	value   json.RawMessage
+	expires time.Time
+}
+
+func newQueryCache(ttl time.Duration, maxBytes int) *queryCache {
+	return &queryCache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		entries:  list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *queryCache) get(key string) (json.RawMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*queryCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.entries.MoveToFront(e)
+	return entry.value, true
+}
+
+func (c *queryCache) set(key string, value json.RawMessage) {
+	size := len(key) + len(value)
+	if size > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.remove(e)
+	}
+	for c.bytes+size > c.maxBytes {
+		c.remove(c.entries.Back())
+	}
+	c.items[key] = c.entries.PushFront(&queryCacheEntry{key: key, value: value, expires: time.Now().Add(c.ttl)})
+	c.bytes += size
+}
+
+func (c *queryCache) remove(e *list.Element) {
+	entry := c.entries.Remove(e).(*queryCacheEntry)
+	delete(c.items, entry.key)
+	c.bytes -= len(entry.key) + len(entry.value)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestQueryCacheEviction(t *testing.T) {
+	c := newQueryCache(time.Minute, 30)
+	c.set("a", json.RawMessage("123456789"))
+	c.set("b", json.RawMessage("123456789"))
+	c.set("c", json.RawMessage("123456789"))
+	if c.bytes != 30 {
+		t.Fatalf("bytes = %d, want 30", c.bytes)
+	}
+	//note: a becomes the most recently used entry, b is evicted first
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("a should be cached")
+	}
+	c.set("d", json.RawMessage("12345"))
+	if _, ok := c.get("b"); ok {
+		t.Error("b should have been evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.get(key); !ok {
+			t.Errorf("%s should be cached", key)
+		}
+	}
+	if c.bytes != 26 {
+		t.Errorf("bytes = %d, want 26", c.bytes)
+	}
+}
+
+func TestQueryCacheReplace(t *testing.T) {
+	c := newQueryCache(time.Minute, 100)
+	c.set("a", json.RawMessage("123456789"))
+	c.set("a", json.RawMessage("1"))
+	if v, _ := c.get("a"); string(v) != "1" {
+		t.Errorf("a = %s, want 1", v)
+	}
+	if c.bytes != 2 || c.entries.Len() != 1 || len(c.items) != 1 {
+		t.Errorf("bytes = %d, entries = %d, items = %d, want 2, 1, 1", c.bytes, c.entries.Len(), len(c.items))
+	}
+}
+
+func TestQueryCacheTooLarge(t *testing.T) {
+	c := newQueryCache(time.Minute, 10)
+	c.set("a", json.RawMessage("1"))
+	c.set("b", json.RawMessage("123456789012"))
+	if _, ok := c.get("b"); ok {
+		t.Error("b is larger than the cache and should not be cached")
+	}
+	if _, ok := c.get("a"); !ok {
+		t.Error("a should not be evicted by an entry that is not cached")
+	}
+}
+
+func TestQueryCacheExpiry(t *testing.T) {
+	c := newQueryCache(time.Millisecond, 100)
+	c.set("a", json.RawMessage("1"))
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := c.get("a"); ok {
+		t.Error("a should have expired")
+	}
+	if c.bytes != 0 || c.entries.Len() != 0 || len(c.items) != 0 {
+		t.Errorf("bytes = %d, entries = %d, items = %d, want an empty cache", c.bytes, c.entries.Len(), len(c.items))
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+// queryGroup collapses identical concurrent Druid queries into a single
+// upstream execution whose result is shared by every waiter. The upstream
+// execution is only cancelled once all of its waiters went away.
+type queryGroup struct {
+	mu    sync.Mutex
+	calls map[string]*queryCall
+}
+
+type queryCall struct {
+	done    chan struct{}
+	result  json.RawMessage
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+func newQueryGroup() *queryGroup {
+	return &queryGroup{calls: make(map[string]*queryCall)}
+}
+
+func (g *queryGroup) do(ctx context.Context, key string, fn func(ctx context.Context) (json.RawMessage, error)) (json.RawMessage, error) {
+	g.mu.Lock()
+	c, ok := g.calls[key]
+	if ok {
+		c.waiters++
+	} else {
+		callCtx, cancel := context.WithCancel(context.Background())
+		c = &queryCall{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		g.calls[key] = c
+		go func() {
+			c.result, c.err = fn(callCtx)
+			g.mu.Lock()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			close(c.done)
+			cancel()
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.result, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// waitForWaiters waits until the call of key has n waiters.
+func waitForWaiters(t *testing.T, g *queryGroup, key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		g.mu.Lock()
+		c, ok := g.calls[key]
+		waiters := 0
+		if ok {
+			waiters = c.waiters
+		}
+		g.mu.Unlock()
+		if waiters == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("call %s never got %d waiters", key, n)
+}
+
+func TestQueryGroupCollapses(t *testing.T) {
+	g := newQueryGroup()
+	var calls int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (json.RawMessage, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return json.RawMessage("[]"), nil
+	}
+	var wg sync.WaitGroup
+	results := make([]json.RawMessage, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = g.do(context.Background(), "q", fn)
+		}(i)
+	}
+	waitForWaiters(t, g, "q", 3)
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("upstream calls = %d, want 1", calls)
+	}
+	for i, r := range results {
+		if string(r) != "[]" {
+			t.Errorf("result %d = %s, want []", i, r)
+		}
+	}
+}
+
+func TestQueryGroupPartialCancellation(t *testing.T) {
+	g := newQueryGroup()
+	release := make(chan struct{})
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (json.RawMessage, error) {
+		select {
+		case <-release:
+			return json.RawMessage("[1]"), nil
+		case <-ctx.Done():
+			close(cancelled)
+			return nil, ctx.Err()
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	leaving := make(chan error)
+	go func() {
+		_, err := g.do(ctx, "q", fn)
+		leaving <- err
+	}()
+	staying := make(chan json.RawMessage)
+	go func() {
+		r, _ := g.do(context.Background(), "q", fn)
+		staying <- r
+	}()
+	waitForWaiters(t, g, "q", 2)
+
+	cancel()
+	if err := <-leaving; err != context.Canceled {
+		t.Errorf("cancelled waiter error = %v, want %v", err, context.Canceled)
+	}
+	waitForWaiters(t, g, "q", 1)
+	select {
+	case <-cancelled:
+		t.Fatal("upstream call cancelled while a waiter remains")
+	default:
+	}
+	close(release)
+	if r := <-staying; string(r) != "[1]" {
+		t.Errorf("remaining waiter result = %s, want [1]", r)
+	}
+}
+
+func TestQueryGroupCancellation(t *testing.T) {
+	g := newQueryGroup()
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (json.RawMessage, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = g.do(ctx, "q", fn)
+		}(i)
+	}
+	waitForWaiters(t, g, "q", 2)
+	cancel()
+	wg.Wait()
+	for i, err := range errs {
+		if err != context.Canceled {
+			t.Errorf("waiter %d error = %v, want %v", i, err, context.Canceled)
+		}
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream call not cancelled once all waiters left")
+	}
+
+	//note: a new call must not join the cancelled one
+	r, err := g.do(context.Background(), "q", func(ctx context.Context) (json.RawMessage, error) {
+		return json.RawMessage("[2]"), nil
+	})
+	if err != nil || string(r) != "[2]" {
+		t.Errorf("new call = %s, %v, want [2], nil", r, err)
+	}
+}
+
+// panicQueryProcessor is a timeseries processor whose execution panics.
+type panicQueryProcessor struct {
+	timeseriesQueryProcessor
+}
+
+func (p *panicQueryProcessor) Execute(ctx context.Context, q *druidPreparedQuery, s *druidInstanceSettings) (json.RawMessage, error) {
+	panic("execute")
+}
+
+func TestExecuteQueryPanic(t *testing.T) {
+	ds, s := newTestDatasource(t, "http://localhost")
+	ds.RegisterQueryProcessor("timeseries", &panicQueryProcessor{timeseriesQueryProcessor{nativeQueryProcessor{ds: ds}}})
+	qry := backend.DataQuery{
+		TimeRange: backend.TimeRange{From: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)},
+		JSON:      []byte(`{"builder":{"queryType":"timeseries","dataSource":"x","granularity":"hour","intervals":["2020-01-01/2020-01-02"]},"settings":{}}`),
+	}
+	//note: the second run checks that the failed call was released
+	for i := 0; i < 2; i++ {
+		q, settings, err := ds.prepareQuery(context.Background(), qry, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ds.executeQuery(context.Background(), q, s, settings); err == nil {
+			t.Errorf("run %d: expected an error", i)
+		}
+	}
+}
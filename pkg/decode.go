@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type druidColumn struct {
+	Name string
+	Type string
+}
+
+// responseDecoder checks the shape of Druid responses while walking them.
+// Mismatches are reported with the query type, the JSON path and the expected
+// and actual JSON types instead of panicking on a type assertion.
+type responseDecoder struct {
+	queryType string
+}
+
+type decodeError struct {
+	queryType string
+	path      string
+	expected  string
+	actual    string
+}
+
+func (e *decodeError) Error() string {
+	return fmt.Sprintf("unexpected %s response at %s: expected %s, got %s", e.queryType, e.path, e.expected, e.actual)
+}
+
+// results unmarshals a Druid response, which is always a JSON array.
+func (d *responseDecoder) results(result json.RawMessage) ([]interface{}, error) {
+	var v interface{}
+	if err := json.Unmarshal(result, &v); err != nil {
+		return nil, fmt.Errorf("invalid %s response: %w", d.queryType, err)
+	}
+	return d.array("$", v)
+}
+
+func (d *responseDecoder) array(path string, v interface{}) ([]interface{}, error) {
+	a, ok := v.([]interface{})
+	if !ok {
+		return nil, d.error(path, "array", v)
+	}
+	return a, nil
+}
+
+func (d *responseDecoder) object(path string, v interface{}) (map[string]interface{}, error) {
+	o, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, d.error(path, "object", v)
+	}
+	return o, nil
+}
+
+func (d *responseDecoder) string(path string, v interface{}) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", d.error(path, "string", v)
+	}
+	return s, nil
+}
+
+func (d *responseDecoder) error(path string, expected string, v interface{}) error {
+	return &decodeError{queryType: d.queryType, path: path, expected: expected, actual: d.jsonType(v)}
+}
+
+func (d *responseDecoder) jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// typeColumns builds the response columns, typed from the query when it tells
+// and from the values otherwise.
+func (ds *druidDatasource) typeColumns(names []string, rows [][]interface{}, columnTypes map[string]string) []druidColumn {
+	columns := make([]druidColumn, len(names))
+	for i, n := range names {
+		columns[i].Name = n
+		if typ, ok := columnTypes[n]; ok {
+			columns[i].Type = typ
+			continue
+		}
+		ds.detectColumnType(&columns[i], i, rows)
+	}
+	return columns
+}
+
+// detectColumnType guesses a column type from a sample of its values.
+func (ds *druidDatasource) detectColumnType(c *druidColumn, pos int, rr [][]interface{}) {
+	t := map[string]int{"nil": 0}
+	for i := 0; i < len(rr); i += int(math.Ceil(float64(len(rr)) / 5.0)) {
+		r := rr[i]
+		if pos >= len(r) {
+			continue
+		}
+		switch v := r[pos].(type) {
+		case string:
+			_, err := strconv.Atoi(v)
+			if err != nil {
+				_, err := strconv.ParseBool(v)
+				if err != nil {
+					_, err := time.Parse("2006-01-02T15:04:05.000Z", v)
+					if err != nil {
+						t["string"]++
+						continue
+					}
+					t["time"]++
+					continue
+				}
+				t["bool"]++
+				continue
+			}
+			t["int"]++
+			continue
+		case float64:
+			if c.Name == "__time" || strings.Contains(strings.ToLower(c.Name), "time_") {
+				t["time"]++
+				continue
+			}
+			t["float"]++
+			continue
+		case bool:
+			t["bool"]++
+			continue
+		case map[string]interface{}, []interface{}:
+			t["json"]++
+			continue
+		}
+	}
+	election := func(values map[string]int) string {
+		type kv struct {
+			Key   string
+			Value int
+		}
+		var ss []kv
+		for k, v := range values {
+			ss = append(ss, kv{k, v})
+		}
+		sort.Slice(ss, func(i, j int) bool {
+			return ss[i].Value > ss[j].Value
+		})
+		if len(ss) == 2 {
+			return ss[0].Key
+		}
+		return "string"
+	}
+	c.Type = election(t)
+}
@@ -2,19 +2,24 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
+	"net/url"
+	"regexp"
+	"runtime/debug"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bitly/go-simplejson"
 	"github.com/grafadruid/go-druid"
 	druidquerybuilder "github.com/grafadruid/go-druid/builder"
-	druidquery "github.com/grafadruid/go-druid/builder/query"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
@@ -27,12 +32,42 @@ type druidQuery struct {
 	Settings map[string]interface{} `json:"settings"`
 }
 
+// druidPreparedQuery is the Druid query as prepared by the plugin. It is sent
+// as is rather than loaded into the go-druid builder, which doesn't round trip
+// every component (e.g. period granularities).
+type druidPreparedQuery struct {
+	queryType string
+	processor QueryProcessor
+	queryID   string
+	key       string
+	//note: shape is the prepared query without its time range, see incrementalTimeRange
+	shape string
+	//note: column types and order derived from the query, see queryColumnTypes and queryColumnOrder
+	columnTypes map[string]string
+	columnOrder []string
+	//note: granularity, timeGroup, timeBound and timeBucketed are used to split the query in time chunks
+	granularity  interface{}
+	timeGroup    string
+	timeBound    bool
+	timeBucketed bool
+	//note: identity is the user identity forwarded to Druid, see withIdentity
+	identity requestIdentity
+	json     json.RawMessage
+	meta     map[string]interface{}
+}
+
+func (q *druidPreparedQuery) Type() druidquerybuilder.ComponentType {
+	return q.queryType
+}
+
+func (q *druidPreparedQuery) MarshalJSON() ([]byte, error) {
+	return q.json, nil
+}
+
 type druidResponse struct {
-	Columns []struct {
-		Name string
-		Type string
-	}
-	Rows [][]interface{}
+	Columns []druidColumn
+	Rows    [][]interface{}
+	Notices []data.Notice
 }
 
 func newDataSourceInstance(settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
@@ -42,7 +77,12 @@ func newDataSourceInstance(settings backend.DataSourceInstanceSettings) (instanc
 	}
 	secureData := settings.DecryptedSecureJSONData
 
-	var druidOpts []druid.ClientOption
+	headers, userHeaders := customHeaders(data, secureData)
+	httpClient, err := newHTTPClient(data, secureData, headers)
+	if err != nil {
+		return &druidInstanceSettings{}, err
+	}
+	druidOpts := []druid.ClientOption{druid.WithHTTPClient(httpClient)}
 	if retryMax := data.Get("connection.retryableRetryMax").MustInt(-1); retryMax != -1 {
 		druidOpts = append(druidOpts, druid.WithRetryMax(retryMax))
 	}
@@ -52,7 +92,7 @@ func newDataSourceInstance(settings backend.DataSourceInstanceSettings) (instanc
 	if retryWaitMax := data.Get("connection.retryableRetryWaitMax").MustInt(-1); retryWaitMax != -1 {
 		druidOpts = append(druidOpts, druid.WithRetryWaitMax(time.Duration(retryWaitMax)*time.Millisecond))
 	}
-	if basicAuth := data.Get("connection.basicAuth").MustBool(); basicAuth {
+	if authMode(data) == "basic" {
 		druidOpts = append(druidOpts, druid.WithBasicAuth(data.Get("connection.basicAuthUser").MustString(), secureData["connection.basicAuthPassword"]))
 	}
 
@@ -60,15 +100,62 @@ func newDataSourceInstance(settings backend.DataSourceInstanceSettings) (instanc
 	if err != nil {
 		return &druidInstanceSettings{}, err
 	}
+	var timeCache *timeRangeCache
+	if incrementalCacheTTL := data.Get("query.incrementalCacheTtl").MustInt(defaultIncrementalCacheTTL); incrementalCacheTTL > 0 {
+		timeCache = newTimeRangeCache(time.Duration(incrementalCacheTTL)*time.Millisecond, data.Get("query.incrementalCacheMaxEntries").MustInt(defaultIncrementalCacheMaxEntries))
+	}
+	var cache *queryCache
+	if cacheTTL := data.Get("query.cacheTtl").MustInt(0); cacheTTL > 0 {
+		cache = newQueryCache(time.Duration(cacheTTL)*time.Millisecond, data.Get("query.cacheMaxBytes").MustInt(defaultCacheMaxBytes))
+	}
+	forwardUser := data.Get("connection.forwardUser").MustString()
+	forwardUserHeader := data.Get("connection.forwardUserHeader").MustString()
+	forwardUserContextKey := data.Get("query.forwardUserContextKey").MustString()
+	if forwardUser != "" && forwardUserHeader == "" && forwardUserContextKey == "" {
+		forwardUserHeader = defaultForwardUserHeader
+	}
+	maxConcurrentQueries := data.Get("connection.maxConcurrentQueries").MustInt(defaultMaxConcurrentQueries)
+	if maxConcurrentQueries < 1 {
+		maxConcurrentQueries = 1
+	}
 	return &druidInstanceSettings{
 		client:                 c,
+		queries:                make(chan struct{}, maxConcurrentQueries),
+		cache:                  cache,
+		inflight:               newQueryGroup(),
+		timeCache:              timeCache,
 		queryContextParameters: data.Get("query.contextParameters").MustArray(),
+		queryTimeZone:          data.Get("query.timeZone").MustString("UTC"),
+		forwardOauthIdentity:   data.Get("oauthPassThru").MustBool(),
+		forwardUser:            forwardUser,
+		forwardUserHeader:      forwardUserHeader,
+		forwardUserContextKey:  forwardUserContextKey,
+		userHeaders:            userHeaders,
 	}, nil
 }
 
+const (
+	defaultMaxConcurrentQueries       = 4
+	defaultCacheMaxBytes              = 64 << 20
+	defaultIncrementalCacheTTL        = 600000 // ms
+	defaultIncrementalCacheMaxEntries = 256
+	defaultForwardUserHeader          = "X-Grafana-User"
+)
+
 type druidInstanceSettings struct {
-	client                 *druid.Client
+	client *druid.Client
+	//note: queries limits the concurrent broker queries of the datasource, see doQuery
+	queries                chan struct{}
+	cache                  *queryCache
+	inflight               *queryGroup
+	timeCache              *timeRangeCache
 	queryContextParameters []interface{}
+	queryTimeZone          string
+	forwardOauthIdentity   bool
+	forwardUser            string
+	forwardUserHeader      string
+	forwardUserContextKey  string
+	userHeaders            map[string]string
 }
 
 func (s *druidInstanceSettings) Dispose() {
@@ -77,8 +164,10 @@ func (s *druidInstanceSettings) Dispose() {
 
 func newDatasource() datasource.ServeOpts {
 	ds := &druidDatasource{
-		im: datasource.NewInstanceManager(newDataSourceInstance),
+		im:         datasource.NewInstanceManager(newDataSourceInstance),
+		processors: make(map[string]QueryProcessor),
 	}
+	ds.registerQueryProcessors()
 
 	return datasource.ServeOpts{
 		QueryDataHandler:    ds,
@@ -88,7 +177,8 @@ func newDatasource() datasource.ServeOpts {
 }
 
 type druidDatasource struct {
-	im instancemgmt.InstanceManager
+	im         instancemgmt.InstanceManager
+	processors map[string]QueryProcessor
 }
 
 func (ds *druidDatasource) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
@@ -128,19 +218,28 @@ func (ds *druidDatasource) QueryVariableData(ctx context.Context, req *backend.C
 	if err != nil {
 		return []grafanaMetricFindValue{}, err
 	}
-	return ds.queryVariable(req.Body, s)
+	var authorization string
+	if h := req.Headers["Authorization"]; len(h) > 0 {
+		authorization = h[0]
+	}
+	ctx = ds.withIdentity(ctx, s, req.PluginContext, authorization)
+	return ds.queryVariable(ctx, req.Body, s)
 }
 
-func (ds *druidDatasource) queryVariable(qry []byte, s *druidInstanceSettings) ([]grafanaMetricFindValue, error) {
+func (ds *druidDatasource) queryVariable(ctx context.Context, qry []byte, s *druidInstanceSettings) (response []grafanaMetricFindValue, err error) {
 	log.DefaultLogger.Info("DRUID EXECUTE QUERY VARIABLE", "_________________________GRAFANA QUERY___________________________", string(qry))
-	//feature: probably implement a short (1s ? 500ms ? configurable in datasource ? beware memory: constrain size ?) life cache (druidInstanceSettings.cache ?) and early return then
-	response := []grafanaMetricFindValue{}
-	q, stg, err := ds.prepareQuery(qry, s)
+	defer func() {
+		if p := recover(); p != nil {
+			response, err = []grafanaMetricFindValue{}, ds.panicError(p)
+		}
+	}()
+	response = []grafanaMetricFindValue{}
+	q, stg, err := ds.prepareQuery(ctx, backend.DataQuery{JSON: qry}, s)
 	if err != nil {
 		return response, err
 	}
 	log.DefaultLogger.Info("DRUID EXECUTE QUERY VARIABLE", "_________________________DRUID QUERY___________________________", q)
-	r, err := ds.executeQuery(q, s, stg)
+	r, err := ds.executeQuery(ctx, q, s, stg)
 	if err != nil {
 		return response, err
 	}
@@ -158,26 +257,39 @@ func (ds *druidDatasource) prepareVariableResponse(resp *druidResponse, settings
 			switch c.Type {
 			case "string":
 				if r[ic] != nil {
-					response = append(response, grafanaMetricFindValue{Value: r[ic].(string), Text: r[ic].(string)})
+					v := ds.jsonString(r[ic])
+					response = append(response, grafanaMetricFindValue{Value: v, Text: v})
 				}
 			case "float":
-				if r[ic] != nil {
-					response = append(response, grafanaMetricFindValue{Value: r[ic].(float64), Text: fmt.Sprintf("%f", r[ic].(float64))})
+				if v, ok := r[ic].(float64); ok {
+					response = append(response, grafanaMetricFindValue{Value: v, Text: fmt.Sprintf("%f", v)})
 				}
 			case "int":
-				if r[ic] != nil {
-					i, err := strconv.Atoi(r[ic].(string))
+				switch v := r[ic].(type) {
+				case float64:
+					response = append(response, grafanaMetricFindValue{Value: int64(v), Text: strconv.FormatInt(int64(v), 10)})
+				case string:
+					i, err := strconv.Atoi(v)
 					if err != nil {
 						i = 0
 					}
-					response = append(response, grafanaMetricFindValue{Value: i, Text: r[ic].(string)})
+					response = append(response, grafanaMetricFindValue{Value: i, Text: v})
+				}
+			case "json":
+				if r[ic] != nil {
+					v := ds.jsonString(r[ic])
+					response = append(response, grafanaMetricFindValue{Value: v, Text: v})
 				}
 			case "bool":
 				var b bool
 				var err error
-				b, ok := r[ic].(bool)
-				if !ok {
-					b, err = strconv.ParseBool(r[ic].(string))
+				switch v := r[ic].(type) {
+				case bool:
+					b = v
+				case float64:
+					b = v != 0
+				case string:
+					b, err = strconv.ParseBool(v)
 					if err != nil {
 						b = false
 					}
@@ -220,13 +332,18 @@ func (ds *druidDatasource) CheckHealth(ctx context.Context, req *backend.CheckHe
 
 	i, err := ds.im.Get(req.PluginContext)
 	if err != nil {
-		result.Message = "Can't get Druid instance"
+		result.Message = fmt.Sprintf("Can't get Druid instance: %s", err)
 		return result, nil
 	}
 
 	status, _, err := i.(*druidInstanceSettings).client.Common().Status()
 	if err != nil {
 		result.Message = "Can't fetch Druid status"
+		if isProxyError(err) {
+			result.Message = fmt.Sprintf("Can't connect to Druid through the proxy: %s", err)
+		} else if isCertificateError(err) {
+			result.Message = fmt.Sprintf("Can't verify Druid TLS certificate: %s", err)
+		}
 		return result, nil
 	}
 
@@ -243,9 +360,20 @@ func (ds *druidDatasource) QueryData(ctx context.Context, req *backend.QueryData
 		return response, err
 	}
 
+	ctx = ds.withIdentity(ctx, s, req.PluginContext, req.Headers["Authorization"])
+	var mu sync.Mutex
+	var wg sync.WaitGroup
 	for _, q := range req.Queries {
-		response.Responses[q.RefID] = ds.query(q, s)
+		wg.Add(1)
+		go func(q backend.DataQuery) {
+			defer wg.Done()
+			r := ds.query(ctx, q, s)
+			mu.Lock()
+			response.Responses[q.RefID] = r
+			mu.Unlock()
+		}(q)
 	}
+	wg.Wait()
 
 	return response, nil
 }
@@ -258,65 +386,388 @@ func (ds *druidDatasource) settings(ctx backend.PluginContext) (*druidInstanceSe
 	return s.(*druidInstanceSettings), nil
 }
 
-func (ds *druidDatasource) query(qry backend.DataQuery, s *druidInstanceSettings) backend.DataResponse {
+func (ds *druidDatasource) query(ctx context.Context, qry backend.DataQuery, s *druidInstanceSettings) (response backend.DataResponse) {
 	log.DefaultLogger.Info("DRUID EXECUTE QUERY", "_________________________GRAFANA QUERY___________________________", qry)
-	//feature: probably implement a short (1s ? 500ms ? configurable in datasource ? beware memory: constrain size ?) life cache (druidInstanceSettings.cache ?) and early return then
-	response := backend.DataResponse{}
-	q, stg, err := ds.prepareQuery(qry.JSON, s)
+	defer func() {
+		if p := recover(); p != nil {
+			response = backend.DataResponse{Error: ds.panicError(p)}
+		}
+	}()
+	q, stg, err := ds.prepareQuery(ctx, qry, s)
 	if err != nil {
 		response.Error = err
 		return response
 	}
 	log.DefaultLogger.Info("DRUID EXECUTE QUERY", "_________________________DRUID QUERY___________________________", q)
-	r, err := ds.executeQuery(q, s, stg)
+	var r *druidResponse
+	if key, align, ok := ds.incrementalTimeRange(q, stg, s); ok {
+		var hit bool
+		r, hit, err = ds.executeIncrementalQuery(ctx, qry, key, align, s)
+		q.meta["incrementalCache"] = "miss"
+		if hit {
+			q.meta["incrementalCache"] = "hit"
+		}
+	} else if ranges := ds.splitTimeRange(q, qry, stg); len(ranges) > 1 {
+		q.meta["chunks"] = len(ranges)
+		r, err = ds.executeSplitQuery(ctx, qry, ranges, s)
+	} else {
+		r, err = ds.executeQuery(ctx, q, s, stg)
+	}
 	if err != nil {
 		response.Error = err
 		return response
 	}
 	log.DefaultLogger.Info("DRUID EXECUTE QUERY", "_________________________DRUID RESPONSE___________________________", r)
-	response, err = ds.prepareResponse(r, stg)
+	response, err = ds.prepareResponse(r, qry.RefID, stg)
 	if err != nil {
 		//note: error could be set from prepareResponse but this gives a chance to react to error here
 		response.Error = err
 	}
+	ds.setFramesMeta(&response, q.meta)
 	log.DefaultLogger.Info("DRUID EXECUTE QUERY", "_________________________GRAFANA RESPONSE___________________________", response)
 	return response
 }
 
-func (ds *druidDatasource) prepareQuery(qry []byte, s *druidInstanceSettings) (druidquerybuilder.Query, map[string]interface{}, error) {
+// panicError turns a recovered panic into an error so that one malformed query
+// or response can't crash the plugin process.
+func (ds *druidDatasource) panicError(p interface{}) error {
+	log.DefaultLogger.Error("DRUID PANIC", "panic", p, "stack", string(debug.Stack()))
+	return fmt.Errorf("internal error: %v", p)
+}
+
+func (ds *druidDatasource) asArray(v interface{}) []interface{} {
+	a, _ := v.([]interface{})
+	return a
+}
+
+func (ds *druidDatasource) prepareQuery(ctx context.Context, qry backend.DataQuery, s *druidInstanceSettings) (*druidPreparedQuery, map[string]interface{}, error) {
 	var q druidQuery
-	err := json.Unmarshal(qry, &q)
+	err := json.Unmarshal(qry.JSON, &q)
 	if err != nil {
 		return nil, nil, err
 	}
+	if q.Builder == nil {
+		return nil, nil, errors.New("query has no builder")
+	}
+	queryType, _ := q.Builder["queryType"].(string)
+	processor, ok := ds.processors[queryType]
+	if !ok {
+		if queryType == "" {
+			return nil, nil, errors.New("query has no query type")
+		}
+		processor = &passthroughQueryProcessor{nativeQueryProcessor{ds: ds}}
+	}
+	prepared := &druidPreparedQuery{queryType: queryType, processor: processor, meta: make(map[string]interface{})}
 
 	if queryContextParameters, ok := q.Settings["contextParameters"]; ok {
 		q.Builder["context"] = ds.mergeQueryContexts(
 			ds.prepareQueryContext(s.queryContextParameters),
-			ds.prepareQueryContext(queryContextParameters.([]interface{})))
+			ds.prepareQueryContext(ds.asArray(queryContextParameters)))
 	} else {
 		q.Builder["context"] = ds.prepareQueryContext(s.queryContextParameters)
 	}
+	prepared.identity = ds.identity(ctx)
+	if prepared.identity.user != "" && s.forwardUserContextKey != "" {
+		q.Builder["context"].(map[string]interface{})[s.forwardUserContextKey] = prepared.identity.user
+	}
 
-	jsonQuery, err := json.Marshal(q.Builder)
+	var restrictingIntervals []interface{}
+	if binding, ok := q.Settings["timeRangeBinding"].(string); ok && binding != "" && !qry.TimeRange.From.IsZero() {
+		if binding == "intersect" {
+			restrictingIntervals = ds.restrictingIntervals(q.Builder["intervals"], qry.TimeRange)
+		}
+		if err := ds.bindIntervals(q.Builder, binding, qry.TimeRange); err != nil {
+			return nil, nil, err
+		}
+		prepared.timeBound = queryType != "sql" && queryType != "dataSourceMetadata"
+	}
+
+	if autoGranularity, _ := q.Settings["autoGranularity"].(bool); autoGranularity && !qry.TimeRange.From.IsZero() {
+		switch queryType {
+		case "timeseries", "topN", "groupBy", "search":
+			timeZone := s.queryTimeZone
+			if tz, ok := q.Settings["timeZone"].(string); ok && tz != "" {
+				timeZone = tz
+			}
+			min, _ := q.Settings["autoGranularityMin"].(float64)
+			max, _ := q.Settings["autoGranularityMax"].(float64)
+			period := ds.autoGranularity(qry, time.Duration(min)*time.Millisecond, time.Duration(max)*time.Millisecond)
+			q.Builder["granularity"] = map[string]interface{}{
+				"type":     "period",
+				"period":   period,
+				"timeZone": timeZone,
+			}
+			prepared.meta["granularity"] = period
+		}
+	}
 
+	var sqlTemplate string
+	if sql, ok := q.Builder["query"].(string); ok && queryType == "sql" && !qry.TimeRange.From.IsZero() {
+		sqlTemplate = sql
+		q.Builder["query"], err = ds.expandSQLMacros(sql, qry, ds.exclusiveStop(ctx))
+		if err != nil {
+			return nil, nil, err
+		}
+		prepared.timeBound = strings.Contains(sql, "$__timeFilter(") || strings.Contains(sql, "$__unixEpochFilter(")
+		prepared.timeGroup = ds.sqlTimeGroupPeriod(sql, qry)
+	}
+
+	prepared.timeBucketed = ds.timeBucketed(q.Builder, prepared.timeGroup)
+
+	if err := processor.Prepare(q.Builder, q.Settings); err != nil {
+		return nil, nil, err
+	}
+
+	prepared.granularity = q.Builder["granularity"]
+	if queryType != "sql" {
+		prepared.columnTypes = ds.queryColumnTypes(q.Builder)
+		prepared.columnOrder = ds.queryColumnOrder(q.Builder)
+	}
+
+	//note: the cache key is the prepared query without the generated query id
+	key, err := json.Marshal(q.Builder)
 	if err != nil {
 		return nil, nil, err
 	}
-	log.DefaultLogger.Info("DRUID EXECUTE QUERY", "_________________________DRUID JSON QUERY___________________________", string(jsonQuery))
+	prepared.key = string(key) + prepared.identity.key()
+	if prepared.timeBound {
+		//note: bound intervals and expanded SQL time filters follow the time range, the frontend interpolated intervals too
+		shape := make(map[string]interface{}, len(q.Builder))
+		for k, v := range q.Builder {
+			shape[k] = v
+		}
+		delete(shape, "intervals")
+		if len(restrictingIntervals) > 0 {
+			shape["intervals"] = restrictingIntervals
+		}
+		if queryType == "sql" {
+			shape["query"] = sqlTemplate
+		}
+		key, err := json.Marshal(shape)
+		if err != nil {
+			return nil, nil, err
+		}
+		prepared.shape = string(key) + prepared.identity.key()
+	}
 
-	query, err := s.client.Query().Load(jsonQuery)
-	//feature: could ensure __time column is selected, time interval is set based on qry given timerange and consider max data points ?
+	//note: the query id lets the broker cancel the query if the Grafana request goes away
+	queryIDKey := processor.QueryIDKey()
+	queryContext := q.Builder["context"].(map[string]interface{})
+	if id, ok := queryContext[queryIDKey].(string); ok && id != "" {
+		prepared.queryID = id
+	} else {
+		prepared.queryID = ds.newQueryID()
+		queryContext[queryIDKey] = prepared.queryID
+	}
+	prepared.json, err = json.Marshal(q.Builder)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.DefaultLogger.Info("DRUID EXECUTE QUERY", "_________________________DRUID JSON QUERY___________________________", string(prepared.json))
+	//feature: could ensure __time column is selected ?
+
+	return prepared, q.Settings, nil
+}
+
+// granularityPeriods are the periods auto granularity picks from, in ascending order.
+var granularityPeriods = []struct {
+	duration time.Duration
+	period   string
+}{
+	{time.Second, "PT1S"},
+	{5 * time.Second, "PT5S"},
+	{10 * time.Second, "PT10S"},
+	{15 * time.Second, "PT15S"},
+	{30 * time.Second, "PT30S"},
+	{time.Minute, "PT1M"},
+	{5 * time.Minute, "PT5M"},
+	{10 * time.Minute, "PT10M"},
+	{15 * time.Minute, "PT15M"},
+	{30 * time.Minute, "PT30M"},
+	{time.Hour, "PT1H"},
+	{3 * time.Hour, "PT3H"},
+	{6 * time.Hour, "PT6H"},
+	{12 * time.Hour, "PT12H"},
+	{24 * time.Hour, "P1D"},
+	{7 * 24 * time.Hour, "P1W"},
+	{30 * 24 * time.Hour, "P1M"},
+	{365 * 24 * time.Hour, "P1Y"},
+}
+
+// autoGranularity returns the smallest granularity period that is at least the
+// Grafana suggested interval and keeps the number of buckets under MaxDataPoints,
+// constrained by min and max when they are set.
+func (ds *druidDatasource) autoGranularity(qry backend.DataQuery, min, max time.Duration) string {
+	interval := ds.effectiveInterval(qry)
+	if min > 0 && interval < min {
+		interval = min
+	}
+	if max > 0 && interval > max {
+		interval = max
+	}
+	idx := len(granularityPeriods) - 1
+	for i, p := range granularityPeriods {
+		if p.duration >= interval {
+			idx = i
+			break
+		}
+	}
+	for max > 0 && idx > 0 && granularityPeriods[idx].duration > max {
+		idx--
+	}
+	return granularityPeriods[idx].period
+}
+
+// bindIntervals replaces (binding "replace") or intersects (binding "intersect")
+// the builder intervals with the Grafana time range. Sql and dataSourceMetadata
+// queries have no intervals and are left untouched.
+func (ds *druidDatasource) bindIntervals(builder map[string]interface{}, binding string, timeRange backend.TimeRange) error {
+	switch builder["queryType"] {
+	case "sql", "dataSourceMetadata":
+		return nil
+	}
+	from, to := timeRange.From.UTC(), timeRange.To.UTC()
+	var intervals []interface{}
+	switch binding {
+	case "replace":
+		intervals = append(intervals, ds.formatInterval(from, to))
+	case "intersect":
+		builderIntervals, _ := builder["intervals"].([]interface{})
+		for _, i := range builderIntervals {
+			start, stop, err := ds.parseInterval(i)
+			if err != nil {
+				//note: templated intervals, e.g. ${__from:date:iso}, are not interpolated in alert evaluation, let's bind them to the time range
+				log.DefaultLogger.Debug("DRUID BIND INTERVALS", "interval", i, "error", err.Error())
+				start, stop = from, to
+			}
+			if start.Before(from) {
+				start = from
+			}
+			if stop.After(to) {
+				stop = to
+			}
+			if start.Before(stop) {
+				intervals = append(intervals, ds.formatInterval(start, stop))
+			}
+		}
+		if len(intervals) == 0 {
+			//note: druid requires at least one interval, an empty one matches no rows
+			intervals = append(intervals, ds.formatInterval(from, from))
+		}
+	default:
+		return fmt.Errorf("unknown time range binding: %s", binding)
+	}
+	builder["intervals"] = intervals
+	return nil
+}
+
+// intervalTimeLayouts are the ISO 8601 time forms accepted in Druid intervals,
+// times without offset being UTC ones.
+var intervalTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02T15",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+var isoPeriodRegexp = regexp.MustCompile(`^P(?:(\d+)Y)?(?:(\d+)M)?(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseInterval parses a Druid ISO 8601 interval: start/stop, start/period or
+// period/stop.
+func (ds *druidDatasource) parseInterval(interval interface{}) (time.Time, time.Time, error) {
+	var start, stop time.Time
+	i, ok := interval.(string)
+	if !ok {
+		return start, stop, fmt.Errorf("invalid interval: %v", interval)
+	}
+	parts := strings.Split(i, "/")
+	if len(parts) != 2 {
+		return start, stop, fmt.Errorf("invalid interval: %s", i)
+	}
+	start, startErr := ds.parseIntervalTime(parts[0])
+	stop, stopErr := ds.parseIntervalTime(parts[1])
+	switch {
+	case startErr == nil && stopErr == nil:
+	case startErr == nil:
+		if stop, ok = ds.addISOPeriod(start, parts[1], 1); !ok {
+			return start, stop, fmt.Errorf("invalid interval stop: %s", i)
+		}
+	case stopErr == nil:
+		if start, ok = ds.addISOPeriod(stop, parts[0], -1); !ok {
+			return start, stop, fmt.Errorf("invalid interval start: %s", i)
+		}
+	default:
+		return start, stop, fmt.Errorf("invalid interval start: %s", i)
+	}
+	return start.UTC(), stop.UTC(), nil
+}
 
-	return query, q.Settings, err
+func (ds *druidDatasource) parseIntervalTime(v string) (time.Time, error) {
+	var err error
+	for _, layout := range intervalTimeLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// addISOPeriod adds sign times an ISO 8601 period, e.g. P1M or PT12H, to t.
+func (ds *druidDatasource) addISOPeriod(t time.Time, period string, sign int) (time.Time, bool) {
+	m := isoPeriodRegexp.FindStringSubmatch(period)
+	if m == nil || period == "P" || strings.HasSuffix(period, "T") {
+		return t, false
+	}
+	var v [6]int
+	for i := range v {
+		if m[i+1] != "" {
+			v[i], _ = strconv.Atoi(m[i+1])
+		}
+	}
+	var seconds float64
+	if m[7] != "" {
+		seconds, _ = strconv.ParseFloat(m[7], 64)
+	}
+	t = t.AddDate(sign*v[0], sign*v[1], sign*(7*v[2]+v[3]))
+	d := time.Duration(v[4])*time.Hour + time.Duration(v[5])*time.Minute + time.Duration(seconds*float64(time.Second))
+	return t.Add(time.Duration(sign) * d), true
+}
+
+func (ds *druidDatasource) formatInterval(start, stop time.Time) string {
+	return start.Format("2006-01-02T15:04:05.000Z") + "/" + stop.Format("2006-01-02T15:04:05.000Z")
+}
+
+func (ds *druidDatasource) setFramesMeta(response *backend.DataResponse, meta map[string]interface{}) {
+	if len(meta) == 0 {
+		return
+	}
+	for _, f := range response.Frames {
+		if f.Meta == nil {
+			f.Meta = &data.FrameMeta{}
+		}
+		custom, ok := f.Meta.Custom.(map[string]interface{})
+		if !ok {
+			custom = make(map[string]interface{})
+		}
+		for k, v := range meta {
+			custom[k] = v
+		}
+		f.Meta.Custom = custom
+	}
 }
 
 func (ds *druidDatasource) prepareQueryContext(parameters []interface{}) map[string]interface{} {
 	ctx := make(map[string]interface{})
 	if parameters != nil {
 		for _, parameter := range parameters {
-			p := parameter.(map[string]interface{})
-			ctx[p["name"].(string)] = p["value"]
+			p, _ := parameter.(map[string]interface{})
+			if name, ok := p["name"].(string); ok {
+				ctx[name] = p["value"]
+			}
 		}
 	}
 	return ctx
@@ -332,482 +783,284 @@ func (ds *druidDatasource) mergeQueryContexts(contexts ...map[string]interface{}
 	return ctx
 }
 
-func (ds *druidDatasource) executeQuery(q druidquerybuilder.Query, s *druidInstanceSettings, settings map[string]interface{}) (*druidResponse, error) {
-	// refactor: probably need to extract per-query preprocessor and postprocessor into a per-query file. load those "plugins" (ak. QueryProcessor ?) into a register and then do something like plugins[q.Type()].preprocess(q) and plugins[q.Type()].postprocess(r)
-	r := &druidResponse{}
-	qtyp := q.Type()
-	switch qtyp {
-	case "sql":
-		q.(*druidquery.SQL).SetResultFormat("array").SetHeader(true)
-	case "scan":
-		q.(*druidquery.Scan).SetResultFormat("compactedList")
+func (ds *druidDatasource) newQueryID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
 	}
-	var result json.RawMessage
-	_, err := s.client.Query().Execute(q, &result)
+	return "grafana-" + hex.EncodeToString(b)
+}
+
+// doQuery posts the query to the broker bound to ctx. When ctx is done before
+// the broker answers, the query is cancelled on the broker side too. The
+// datasource query limit is only held while the broker runs the query, so that
+// split and incremental queries can't exceed it nor wait on themselves.
+func (ds *druidDatasource) doQuery(ctx context.Context, q *druidPreparedQuery, s *druidInstanceSettings, result interface{}) error {
+	req, err := s.client.NewRequest("POST", q.processor.Endpoint(), q)
 	if err != nil {
-		return r, err
-	}
-	var detectColumnType = func(c *struct {
-		Name string
-		Type string
-	}, pos int, rr [][]interface{}) {
-		t := map[string]int{"nil": 0}
-		for i := 0; i < len(rr); i += int(math.Ceil(float64(len(rr)) / 5.0)) {
-			r := rr[i]
-			switch r[pos].(type) {
-			case string:
-				v := r[pos].(string)
-				_, err := strconv.Atoi(v)
-				if err != nil {
-					_, err := strconv.ParseBool(v)
-					if err != nil {
-						_, err := time.Parse("2006-01-02T15:04:05.000Z", v)
-						if err != nil {
-							t["string"]++
-							continue
-						}
-						t["time"]++
-						continue
-					}
-					t["bool"]++
-					continue
-				}
-				t["int"]++
-				continue
-			case float64:
-				if c.Name == "__time" || strings.Contains(strings.ToLower(c.Name), "time_") {
-					t["time"]++
-					continue
+		return err
+	}
+	ds.setIdentityHeaders(req.Header, q, s)
+	select {
+	case s.queries <- struct{}{}:
+		defer func() { <-s.queries }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	_, err = s.client.Do(req.WithContext(ctx), result)
+	if err != nil && ctx.Err() != nil {
+		go ds.cancelQuery(q, s)
+		return ctx.Err()
+	}
+	return err
+}
+
+func (ds *druidDatasource) cancelQuery(q *druidPreparedQuery, s *druidInstanceSettings) {
+	if q.queryID == "" {
+		return
+	}
+	path := q.processor.Endpoint() + "/" + url.PathEscape(q.queryID)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := s.client.NewRequest("DELETE", path, nil)
+	if err != nil {
+		log.DefaultLogger.Error("DRUID CANCEL QUERY", "queryId", q.queryID, "error", err.Error())
+		return
+	}
+	ds.setIdentityHeaders(req.Header, q, s)
+	if _, err := s.client.Do(req.WithContext(ctx), nil); err != nil {
+		log.DefaultLogger.Error("DRUID CANCEL QUERY", "queryId", q.queryID, "error", err.Error())
+	}
+}
+
+func (ds *druidDatasource) executeQuery(ctx context.Context, q *druidPreparedQuery, s *druidInstanceSettings, settings map[string]interface{}) (*druidResponse, error) {
+	r := &druidResponse{}
+	var result json.RawMessage
+	bypassCache, _ := settings["bypassCache"].(bool)
+	useCache := s.cache != nil && !bypassCache
+	var err error
+	var hit bool
+	if useCache {
+		result, hit = s.cache.get(q.key)
+	}
+	if hit {
+		q.meta["cache"] = "hit"
+	} else {
+		result, err = s.inflight.do(ctx, q.key, func(ctx context.Context) (result json.RawMessage, err error) {
+			//note: the call runs on its own goroutine, out of reach of the recover of the query
+			defer func() {
+				if p := recover(); p != nil {
+					err = ds.panicError(p)
 				}
-				t["float"]++
-				continue
-			case bool:
-				t["bool"]++
-				continue
-			}
+			}()
+			return q.processor.Execute(ctx, q, s)
+		})
+		if err != nil {
+			return r, err
 		}
-		election := func(values map[string]int) string {
-			type kv struct {
-				Key   string
-				Value int
-			}
-			var ss []kv
-			for k, v := range values {
-				ss = append(ss, kv{k, v})
-			}
-			sort.Slice(ss, func(i, j int) bool {
-				return ss[i].Value > ss[j].Value
-			})
-			if len(ss) == 2 {
-				return ss[0].Key
-			}
-			return "string"
+		if useCache {
+			s.cache.set(q.key, result)
+			q.meta["cache"] = "miss"
 		}
-		c.Type = election(t)
 	}
-	switch qtyp {
-	case "sql":
-		var sqlr []interface{}
-		err := json.Unmarshal(result, &sqlr)
-		if err == nil && len(sqlr) > 1 {
-			for _, row := range sqlr[1:] {
-				r.Rows = append(r.Rows, row.([]interface{}))
-			}
-			for i, c := range sqlr[0].([]interface{}) {
-				col := struct {
-					Name string
-					Type string
-				}{Name: c.(string)}
-				detectColumnType(&col, i, r.Rows)
-				r.Columns = append(r.Columns, col)
-			}
+	return q.processor.Decode(q, settings, result)
+}
+
+// orderedKeys returns the keys of m following order first, then the keys
+// missing from order sorted alphabetically.
+func (ds *druidDatasource) orderedKeys(m map[string]interface{}, order []string) []string {
+	keys := make([]string, 0, len(m))
+	seen := make(map[string]bool)
+	for _, k := range order {
+		if _, ok := m[k]; ok && !seen[k] {
+			keys = append(keys, k)
+			seen[k] = true
 		}
-	case "timeseries":
-		var tsr []map[string]interface{}
-		err := json.Unmarshal(result, &tsr)
-		if err == nil && len(tsr) > 0 {
-			var columns = []string{"timestamp"}
-			for c := range tsr[0]["result"].(map[string]interface{}) {
-				columns = append(columns, c)
-			}
-			for _, result := range tsr {
-				var row []interface{}
-				t := result["timestamp"]
-				if t == nil {
-					//grand total, lets keep it last
-					t = r.Rows[len(r.Rows)-1][0]
-				}
-				row = append(row, t)
-				colResults := result["result"].(map[string]interface{})
-				for _, c := range columns[1:] {
-					row = append(row, colResults[c])
-				}
-				r.Rows = append(r.Rows, row)
-			}
-			for i, c := range columns {
-				col := struct {
-					Name string
-					Type string
-				}{Name: c}
-				detectColumnType(&col, i, r.Rows)
-				r.Columns = append(r.Columns, col)
-			}
+	}
+	var others []string
+	for k := range m {
+		if !seen[k] {
+			others = append(others, k)
 		}
-	case "topN":
-		var tn []map[string]interface{}
-		err := json.Unmarshal(result, &tn)
-		if err == nil && len(tn) > 0 {
-			var columns = []string{"timestamp"}
-			for c := range tn[0]["result"].([]interface{})[0].(map[string]interface{}) {
-				columns = append(columns, c)
-			}
-			for _, result := range tn {
-				for _, record := range result["result"].([]interface{}) {
-					var row []interface{}
-					row = append(row, result["timestamp"])
-					o := record.(map[string]interface{})
-					for _, c := range columns[1:] {
-						row = append(row, o[c])
-					}
-					r.Rows = append(r.Rows, row)
-				}
-			}
-			for i, c := range columns {
-				col := struct {
-					Name string
-					Type string
-				}{Name: c}
-				detectColumnType(&col, i, r.Rows)
-				r.Columns = append(r.Columns, col)
+	}
+	sort.Strings(others)
+	return append(keys, others...)
+}
+
+// columnFieldTypes maps column types to Grafana field types.
+var columnFieldTypes = map[string]data.FieldType{
+	"string": data.FieldTypeString,
+	"float":  data.FieldTypeFloat64,
+	"int":    data.FieldTypeInt64,
+	"bool":   data.FieldTypeBool,
+	"time":   data.FieldTypeTime,
+	"json":   data.FieldTypeString,
+	"nil":    data.FieldTypeString,
+}
+
+// convertValue converts a Druid value to the Go type of its column type. It
+// returns nil for null values and values that can't be converted.
+func (ds *druidDatasource) convertValue(columnType string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch columnType {
+	case "float":
+		switch v := v.(type) {
+		case float64:
+			return v
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f
 			}
 		}
-	case "groupBy":
-		var gb []map[string]interface{}
-		err := json.Unmarshal(result, &gb)
-		if err == nil && len(gb) > 0 {
-			var columns = []string{"timestamp"}
-			for c := range gb[0]["event"].(map[string]interface{}) {
-				columns = append(columns, c)
-			}
-			for _, result := range gb {
-				var row []interface{}
-				row = append(row, result["timestamp"])
-				colResults := result["event"].(map[string]interface{})
-				for _, c := range columns[1:] {
-					row = append(row, colResults[c])
-				}
-				r.Rows = append(r.Rows, row)
-			}
-			for i, c := range columns {
-				col := struct {
-					Name string
-					Type string
-				}{Name: c}
-				detectColumnType(&col, i, r.Rows)
-				r.Columns = append(r.Columns, col)
+	case "int":
+		switch v := v.(type) {
+		case float64:
+			return int64(v)
+		case string:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i
 			}
 		}
-	case "scan":
-		var scanr []map[string]interface{}
-		err := json.Unmarshal(result, &scanr)
-		if err == nil && len(scanr) > 0 {
-			for _, e := range scanr[0]["events"].([]interface{}) {
-				r.Rows = append(r.Rows, e.([]interface{}))
-			}
-			for i, c := range scanr[0]["columns"].([]interface{}) {
-				col := struct {
-					Name string
-					Type string
-				}{Name: c.(string)}
-				detectColumnType(&col, i, r.Rows)
-				r.Columns = append(r.Columns, col)
+	case "bool":
+		switch v := v.(type) {
+		case bool:
+			return v
+		case float64:
+			return v != 0
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b
 			}
 		}
-	case "search":
-		var s []map[string]interface{}
-		err := json.Unmarshal(result, &s)
-		if err == nil && len(s) > 0 {
-			var columns = []string{"timestamp"}
-			for c := range s[0]["result"].([]interface{})[0].(map[string]interface{}) {
-				columns = append(columns, c)
-			}
-			for _, result := range s {
-				for _, record := range result["result"].([]interface{}) {
-					var row []interface{}
-					row = append(row, result["timestamp"])
-					o := record.(map[string]interface{})
-					for _, c := range columns[1:] {
-						row = append(row, o[c])
-					}
-					r.Rows = append(r.Rows, row)
-				}
-			}
-			for i, c := range columns {
-				col := struct {
-					Name string
-					Type string
-				}{Name: c}
-				detectColumnType(&col, i, r.Rows)
-				r.Columns = append(r.Columns, col)
-			}
+	case "time":
+		if t, ok := ds.parseTime(v); ok {
+			return t
 		}
-	case "timeBoundary":
-		var tb []map[string]interface{}
-		err := json.Unmarshal(result, &tb)
-		if err == nil && len(tb) > 0 {
-			var columns = []string{"timestamp"}
-			for c := range tb[0]["result"].(map[string]interface{}) {
-				columns = append(columns, c)
-			}
-			for _, result := range tb {
-				var row []interface{}
-				row = append(row, result["timestamp"])
-				colResults := result["result"].(map[string]interface{})
-				for _, c := range columns[1:] {
-					row = append(row, colResults[c])
-				}
-				r.Rows = append(r.Rows, row)
-			}
-			for i, c := range columns {
-				col := struct {
-					Name string
-					Type string
-				}{Name: c}
-				detectColumnType(&col, i, r.Rows)
-				r.Columns = append(r.Columns, col)
-			}
+	default:
+		return ds.jsonString(v)
+	}
+	return nil
+}
+
+// fillNulls applies the null handling of the query to the column values:
+// "zero" replaces nulls with the zero value of the column type, "previous"
+// with the previous non null value. Nulls are kept otherwise.
+func (ds *druidDatasource) fillNulls(columnType string, values []interface{}, nullHandling string) []interface{} {
+	var previous interface{}
+	for i, v := range values {
+		if v != nil {
+			previous = v
+			continue
 		}
-	case "dataSourceMetadata":
-		var dsm []map[string]interface{}
-		err := json.Unmarshal(result, &dsm)
-		if err == nil && len(dsm) > 0 {
-			var columns = []string{"timestamp"}
-			for c := range dsm[0]["result"].(map[string]interface{}) {
-				columns = append(columns, c)
-			}
-			for _, result := range dsm {
-				var row []interface{}
-				row = append(row, result["timestamp"])
-				colResults := result["result"].(map[string]interface{})
-				for _, c := range columns[1:] {
-					row = append(row, colResults[c])
-				}
-				r.Rows = append(r.Rows, row)
-			}
-			for i, c := range columns {
-				col := struct {
-					Name string
-					Type string
-				}{Name: c}
-				detectColumnType(&col, i, r.Rows)
-				r.Columns = append(r.Columns, col)
-			}
+		switch nullHandling {
+		case "zero":
+			values[i] = ds.zeroValue(columnType)
+		case "previous":
+			values[i] = previous
 		}
-	case "segmentMetadata":
-		var sm []map[string]interface{}
-		err := json.Unmarshal(result, &sm)
-		if err == nil && len(sm) > 0 {
-			var columns []string
-			switch settings["view"].(string) {
-			case "base":
-				for k, v := range sm[0] {
-					if k != "aggregators" && k != "columns" && k != "timestampSpec" {
-						if k == "intervals" {
-							for i := range v.([]interface{}) {
-								pos := strconv.Itoa(i)
-								columns = append(columns, "interval_start_"+pos)
-								columns = append(columns, "interval_stop_"+pos)
-							}
-						} else {
-							columns = append(columns, k)
-						}
-					}
-				}
-				for _, result := range sm {
-					var row []interface{}
-					for _, c := range columns {
-						var col interface{}
-						if strings.HasPrefix(c, "interval_") {
-							parts := strings.Split(c, "_")
-							pos := 0
-							if parts[1] == "stop" {
-								pos = 1
-							}
-							idx, err := strconv.Atoi(parts[2])
-							if err != nil {
-								return r, errors.New("interval parsing goes wrong")
-							}
-							ii := result["intervals"].([]interface{})[idx]
-							col = strings.Split(ii.(string), "/")[pos]
-						} else {
-							col = result[c]
-						}
-						row = append(row, col)
-					}
-					r.Rows = append(r.Rows, row)
-				}
-			case "aggregators":
-				for _, v := range sm[0]["aggregators"].(map[string]interface{}) {
-					columns = append(columns, "aggregator")
-					for k := range v.(map[string]interface{}) {
-						columns = append(columns, k)
-					}
-					break
-				}
-				for _, result := range sm {
-					for k, v := range result["aggregators"].(map[string]interface{}) {
-						var row []interface{}
-						for _, c := range columns {
-							var col interface{}
-							if c == "aggregator" {
-								col = k
-							} else {
-								col = v.(map[string]interface{})[c]
-							}
-							row = append(row, col)
-						}
-						r.Rows = append(r.Rows, row)
-					}
-				}
-			case "columns":
-				for _, v := range sm[0]["columns"].(map[string]interface{}) {
-					columns = append(columns, "column")
-					for k := range v.(map[string]interface{}) {
-						columns = append(columns, k)
-					}
-					break
-				}
-				for _, result := range sm {
-					for k, v := range result["columns"].(map[string]interface{}) {
-						var row []interface{}
-						for _, c := range columns {
-							var col interface{}
-							if c == "column" {
-								col = k
-							} else {
-								col = v.(map[string]interface{})[c]
-							}
-							row = append(row, col)
-						}
-						r.Rows = append(r.Rows, row)
-					}
-				}
-			case "timestampspec":
-				for k := range sm[0]["timestampSpec"].(map[string]interface{}) {
-					columns = append(columns, k)
-				}
-				for _, result := range sm {
-					var row []interface{}
-					for _, c := range columns {
-						col := result["timestampSpec"].(map[string]interface{})[c]
-						row = append(row, col)
-					}
-					r.Rows = append(r.Rows, row)
-				}
-			}
-			for i, c := range columns {
-				col := struct {
-					Name string
-					Type string
-				}{Name: c}
-				detectColumnType(&col, i, r.Rows)
-				r.Columns = append(r.Columns, col)
-			}
+	}
+	return values
+}
+
+func (ds *druidDatasource) zeroValue(columnType string) interface{} {
+	switch columnType {
+	case "float":
+		return 0.0
+	case "int":
+		return int64(0)
+	case "bool":
+		return false
+	case "time":
+		return time.Unix(0, 0)
+	}
+	return ""
+}
 
+// newField builds a Grafana field from converted column values. The field is
+// nullable only if some values are null.
+func (ds *druidDatasource) newField(name string, columnType string, values []interface{}) *data.Field {
+	fieldType, ok := columnFieldTypes[columnType]
+	if !ok {
+		fieldType = data.FieldTypeString
+	}
+	for _, v := range values {
+		if v == nil {
+			fieldType = fieldType.NullableType()
+			break
 		}
-	default:
-		return r, errors.New("unknown query type")
 	}
-	return r, err
+	f := data.NewFieldFromFieldType(fieldType, len(values))
+	f.Name = name
+	for i, v := range values {
+		if v != nil {
+			f.SetConcrete(i, v)
+		}
+	}
+	return f
 }
 
-func (ds *druidDatasource) prepareResponse(resp *druidResponse, settings map[string]interface{}) (backend.DataResponse, error) {
-	// refactor: probably some method that returns a container (make([]whattypeever, 0)) and its related appender func based on column type)
+// jsonString returns strings as is and the JSON encoding of any other value.
+func (ds *druidDatasource) jsonString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+func (ds *druidDatasource) prepareResponse(resp *druidResponse, refID string, settings map[string]interface{}) (backend.DataResponse, error) {
 	response := backend.DataResponse{}
-	frame := data.NewFrame("response")
 	hideEmptyColumns, _ := settings["hideEmptyColumns"].(bool)
+	nullHandling, _ := settings["nullHandling"].(string)
+	format, _ := settings["format"].(string)
+	alias, _ := settings["alias"].(string)
+	var columns []responseColumn
 	for ic, c := range resp.Columns {
-		var ff interface{}
+		values := make([]interface{}, len(resp.Rows))
 		columnIsEmpty := true
-		switch c.Type {
-		case "string":
-			ff = make([]string, 0)
-		case "float":
-			ff = make([]float64, 0)
-		case "int":
-			ff = make([]int64, 0)
-		case "bool":
-			ff = make([]bool, 0)
-		case "nil":
-			ff = make([]string, 0)
-		case "time":
-			ff = make([]time.Time, 0)
-		}
-		for _, r := range resp.Rows {
+		for ir, r := range resp.Rows {
 			if columnIsEmpty && r[ic] != nil && r[ic] != "" {
 				columnIsEmpty = false
 			}
-			switch c.Type {
-			case "string":
-				if r[ic] == nil {
-					r[ic] = ""
-				}
-				ff = append(ff.([]string), r[ic].(string))
-			case "float":
-				if r[ic] == nil {
-					r[ic] = 0.0
-				}
-				ff = append(ff.([]float64), r[ic].(float64))
-			case "int":
-				if r[ic] == nil {
-					r[ic] = "0"
-				}
-				i, err := strconv.Atoi(r[ic].(string))
-				if err != nil {
-					i = 0
-				}
-				ff = append(ff.([]int64), int64(i))
-			case "bool":
-				var b bool
-				var err error
-				b, ok := r[ic].(bool)
-				if !ok {
-					b, err = strconv.ParseBool(r[ic].(string))
-					if err != nil {
-						b = false
-					}
-				}
-				ff = append(ff.([]bool), b)
-			case "nil":
-				ff = append(ff.([]string), "nil")
-			case "time":
-				if r[ic] == nil {
-					r[ic] = 0.0
-				}
-				switch r[ic].(type) {
-				case string:
-					t, err := time.Parse("2006-01-02T15:04:05.000Z", r[ic].(string))
-					if err != nil {
-						t = time.Now()
-					}
-					ff = append(ff.([]time.Time), t)
-				case float64:
-					sec, dec := math.Modf(r[ic].(float64) / 1000)
-					ff = append(ff.([]time.Time), time.Unix(int64(sec), int64(dec*(1e9))))
-				}
-			}
+			values[ir] = ds.convertValue(c.Type, r[ic])
 		}
 		if hideEmptyColumns && columnIsEmpty {
 			continue
 		}
-		frame.Fields = append(frame.Fields, data.NewField(c.Name, nil, ff))
+		columns = append(columns, responseColumn{druidColumn: c, values: values})
+	}
+	if format == "timeseries" {
+		if frames := ds.seriesFrames(columns, nullHandling); len(frames) > 0 {
+			if len(resp.Notices) > 0 {
+				frames[0].AppendNotices(resp.Notices...)
+			}
+			ds.applyAlias(frames, alias, refID)
+			response.Frames = frames
+			return response, nil
+		}
+	}
+	frame := data.NewFrame("response")
+	for _, c := range columns {
+		frame.Fields = append(frame.Fields, ds.newField(c.Name, c.Type, ds.fillNulls(c.Type, c.values, nullHandling)))
 	}
-	if format, ok := settings["format"]; ok && format.(string) == "wide" && len(frame.Fields) > 0 {
+	if format == "wide" && len(frame.Fields) > 0 {
 		f, err := data.LongToWide(frame, nil)
 		if err == nil {
 			frame = f
 		}
 	}
+	ds.applyAlias([]*data.Frame{frame}, alias, refID)
+	if len(resp.Notices) > 0 {
+		frame.AppendNotices(resp.Notices...)
+	}
 	response.Frames = append(response.Frames, frame)
 	return response, nil
 }
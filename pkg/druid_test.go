@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestBindIntervals(t *testing.T) {
+	ds := &druidDatasource{}
+	from := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	timeRange := backend.TimeRange{From: from, To: from.Add(24 * time.Hour)}
+	tests := []struct {
+		name      string
+		queryType string
+		binding   string
+		intervals []interface{}
+		want      []string
+	}{
+		{
+			"replace",
+			"timeseries", "replace",
+			[]interface{}{"2019-01-01/2021-01-01", "2022-01-01/2023-01-01"},
+			[]string{"2020-01-01T10:00:00.000Z/2020-01-02T10:00:00.000Z"},
+		},
+		{
+			"intersect start/stop",
+			"timeseries", "intersect",
+			[]interface{}{"2020-01-01T12:00:00Z/2020-01-05"},
+			[]string{"2020-01-01T12:00:00.000Z/2020-01-02T10:00:00.000Z"},
+		},
+		{
+			"intersect offsets",
+			"timeseries", "intersect",
+			[]interface{}{"2020-01-01T12:00:00+02:00/2020-01-01T13:00:00+02:00"},
+			[]string{"2020-01-01T10:00:00.000Z/2020-01-01T11:00:00.000Z"},
+		},
+		{
+			"intersect start/period",
+			"groupBy", "intersect",
+			[]interface{}{"2020-01-01/P1D"},
+			[]string{"2020-01-01T10:00:00.000Z/2020-01-02T00:00:00.000Z"},
+		},
+		{
+			"intersect period/stop",
+			"topN", "intersect",
+			[]interface{}{"PT6H/2020-01-01T14:00:00Z"},
+			[]string{"2020-01-01T10:00:00.000Z/2020-01-01T14:00:00.000Z"},
+		},
+		{
+			"intersect several",
+			"scan", "intersect",
+			[]interface{}{"2019-01-01/2019-02-01", "2020-01-01T20:00/PT2H", "2020-01-02/2020-01-03"},
+			[]string{"2020-01-01T20:00:00.000Z/2020-01-01T22:00:00.000Z", "2020-01-02T00:00:00.000Z/2020-01-02T10:00:00.000Z"},
+		},
+		{
+			"intersect nothing",
+			"timeseries", "intersect",
+			[]interface{}{"2019-01-01/2019-02-01"},
+			[]string{"2020-01-01T10:00:00.000Z/2020-01-01T10:00:00.000Z"},
+		},
+		{
+			"intersect templated",
+			"timeseries", "intersect",
+			[]interface{}{"${__from:date:iso}/${__to:date:iso}"},
+			[]string{"2020-01-01T10:00:00.000Z/2020-01-02T10:00:00.000Z"},
+		},
+		{
+			"sql",
+			"sql", "replace",
+			nil,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		builder := map[string]interface{}{"queryType": tt.queryType}
+		if tt.intervals != nil {
+			builder["intervals"] = tt.intervals
+		}
+		if err := ds.bindIntervals(builder, tt.binding, timeRange); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		intervals, _ := builder["intervals"].([]interface{})
+		if len(intervals) != len(tt.want) {
+			t.Errorf("%s: intervals = %v, want %v", tt.name, intervals, tt.want)
+			continue
+		}
+		for i, want := range tt.want {
+			if intervals[i] != want {
+				t.Errorf("%s: interval %d = %v, want %s", tt.name, i, intervals[i], want)
+			}
+		}
+	}
+	if err := ds.bindIntervals(map[string]interface{}{"queryType": "timeseries"}, "union", timeRange); err == nil {
+		t.Error("unknown binding: expected an error")
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	ds := &druidDatasource{}
+	tests := []struct {
+		interval    string
+		start, stop time.Time
+	}{
+		{"2020-01-01T10:00:00.000Z/2020-01-02T10:00:00.000Z", time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC), time.Date(2020, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{"2020-01/P1M", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"2020/P1Y", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"P1W/2020-01-08", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 1, 8, 0, 0, 0, 0, time.UTC)},
+		{"P1DT12H/2020-01-02T12:00:00Z", time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)},
+		{"2020-01-01T10:00/PT1.5S", time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC), time.Date(2020, 1, 1, 10, 0, 1, 500000000, time.UTC)},
+	}
+	for _, tt := range tests {
+		start, stop, err := ds.parseInterval(tt.interval)
+		if err != nil {
+			t.Errorf("parseInterval(%q): %v", tt.interval, err)
+			continue
+		}
+		if !start.Equal(tt.start) || !stop.Equal(tt.stop) {
+			t.Errorf("parseInterval(%q) = %s, %s, want %s, %s", tt.interval, start, stop, tt.start, tt.stop)
+		}
+	}
+	for _, interval := range []interface{}{42, "2020-01-01", "P1D/P1D", "2020-01-01/P", "2020-01-01/PT", "${__from:date:iso}/${__to:date:iso}"} {
+		if _, _, err := ds.parseInterval(interval); err == nil {
+			t.Errorf("parseInterval(%v): expected an error", interval)
+		}
+	}
+}
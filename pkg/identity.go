@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// requestIdentity is the identity of the Grafana user a query is run for, as
+// forwarded to Druid: the user's OAuth access token when Grafana passes it
+// through, the user's login or email and the custom headers referencing the
+// user.
+type requestIdentity struct {
+	authorization string
+	user          string
+	headers       map[string]string
+}
+
+type identityContextKey struct{}
+
+// withIdentity returns a context carrying the identity to forward for the
+// request, following the datasource settings. authorization is the
+// Authorization header of the plugin request, only set by Grafana when OAuth
+// pass-through is enabled.
+func (ds *druidDatasource) withIdentity(ctx context.Context, s *druidInstanceSettings, pluginContext backend.PluginContext, authorization string) context.Context {
+	var identity requestIdentity
+	if s.forwardOauthIdentity {
+		identity.authorization = authorization
+	}
+	if u := pluginContext.User; u != nil {
+		switch s.forwardUser {
+		case "login":
+			identity.user = u.Login
+		case "email":
+			identity.user = u.Email
+		}
+		for name, value := range s.userHeaders {
+			if identity.headers == nil {
+				identity.headers = make(map[string]string)
+			}
+			identity.headers[name] = headerVariableRegexp.ReplaceAllStringFunc(value, func(m string) string {
+				switch headerVariableRegexp.FindStringSubmatch(m)[1] {
+				case "login":
+					return u.Login
+				case "email":
+					return u.Email
+				}
+				return u.Name
+			})
+		}
+	}
+	return context.WithValue(ctx, identityContextKey{}, identity)
+}
+
+func (ds *druidDatasource) identity(ctx context.Context) requestIdentity {
+	identity, _ := ctx.Value(identityContextKey{}).(requestIdentity)
+	return identity
+}
+
+// key tells identities apart in cache and coalescing keys, so that results
+// are never shared between users Druid may authorize differently.
+func (i requestIdentity) key() string {
+	if i.authorization == "" && i.user == "" && len(i.headers) == 0 {
+		return ""
+	}
+	key := "|" + i.user + "|" + i.authorization
+	names := make([]string, 0, len(i.headers))
+	for name := range i.headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key += "|" + name + ":" + i.headers[name]
+	}
+	return key
+}
+
+// setIdentityHeaders forwards the identity of a query on a Druid request. The
+// forwarded access token replaces the datasource credentials.
+func (ds *druidDatasource) setIdentityHeaders(h http.Header, q *druidPreparedQuery, s *druidInstanceSettings) {
+	if q.identity.authorization != "" {
+		h.Set("Authorization", q.identity.authorization)
+	}
+	if q.identity.user != "" && s.forwardUserHeader != "" {
+		h.Set(s.forwardUserHeader, q.identity.user)
+	}
+	for name, value := range q.identity.headers {
+		h.Set(name, value)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// timeRangeCache keeps the time bucketed responses of recent queries, per
+// query shape, so that a refresh only asks Druid for the buckets it misses.
+type timeRangeCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	entries    map[string]*timeRangeCacheEntry
+}
+
+type timeRangeCacheEntry struct {
+	from     time.Time
+	to       time.Time
+	response *druidResponse
+	updated  time.Time
+}
+
+func newTimeRangeCache(ttl time.Duration, maxEntries int) *timeRangeCache {
+	return &timeRangeCache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*timeRangeCacheEntry),
+	}
+}
+
+func (c *timeRangeCache) get(key string) (*timeRangeCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Since(e.updated) > c.ttl {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e, true
+}
+
+func (c *timeRangeCache) set(key string, e *timeRangeCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		var oldest string
+		for k, v := range c.entries {
+			if oldest == "" || v.updated.Before(c.entries[oldest].updated) {
+				oldest = k
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[key] = e
+}
+
+// incrementalTimeRange tells whether the query can be served by the time range
+// cache and returns its cache key and bucket duration.
+func (ds *druidDatasource) incrementalTimeRange(q *druidPreparedQuery, settings map[string]interface{}, s *druidInstanceSettings) (string, time.Duration, bool) {
+	incremental, _ := settings["incrementalCache"].(bool)
+	if !incremental || s.timeCache == nil || !q.timeBound {
+		return "", 0, false
+	}
+	//note: only time bucketed queries, e.g. sql grouped by $__timeGroup, give the same buckets over a part of the time range
+	align, ok := ds.bucketDuration(q)
+	if !ok {
+		return "", 0, false
+	}
+	//note: settings are part of the key as they change how responses are decoded
+	stg, err := json.Marshal(settings)
+	if err != nil {
+		return "", 0, false
+	}
+	return q.shape + "|" + string(stg) + "|" + align.String(), align, true
+}
+
+// restrictingIntervals returns the query intervals an intersect binding
+// restricts the time range with. Intervals covering the time range, templated
+// ones included, leave it as it is.
+func (ds *druidDatasource) restrictingIntervals(intervals interface{}, timeRange backend.TimeRange) []interface{} {
+	var restricting []interface{}
+	for _, i := range ds.asArray(intervals) {
+		start, stop, err := ds.parseInterval(i)
+		if err != nil || (!start.After(timeRange.From) && !stop.Before(timeRange.To)) {
+			continue
+		}
+		restricting = append(restricting, i)
+	}
+	return restricting
+}
+
+// executeIncrementalQuery serves the query from the time range cache and only
+// asks Druid for the window it doesn't hold yet. The last cached bucket is
+// always fetched again as it may have been incomplete, and so is the first one
+// when the cached time range started elsewhere in it. When the time range stops
+// before the cached one, the bucket it stops in is fetched again. Responses without a time
+// column are not cached as their rows can't be told apart by bucket.
+func (ds *druidDatasource) executeIncrementalQuery(ctx context.Context, qry backend.DataQuery, key string, align time.Duration, s *druidInstanceSettings) (*druidResponse, bool, error) {
+	from, to := qry.TimeRange.From, qry.TimeRange.To
+	fetchFrom, keepFrom := from, from
+	e, hit := s.timeCache.get(key)
+	if hit && !e.from.After(from) && e.to.After(from) {
+		last := e.to
+		if last.After(to) {
+			//note: zoomed in, the cached buckets after to are left out and the one of to is cut by it
+			last = to
+		}
+		fetchFrom = ds.floorTime(last, align)
+		if first := ds.floorTime(from, align); first.Before(from) && !e.from.Equal(from) {
+			keepFrom = first.Add(align)
+		}
+		if !fetchFrom.After(keepFrom) {
+			fetchFrom, keepFrom, hit = from, from, false
+		}
+	} else {
+		hit = false
+	}
+	interval := ds.effectiveInterval(qry)
+	var responses []*druidResponse
+	if hit && keepFrom.After(from) {
+		//note: the keepFrom bucket is a cached one
+		head, err := ds.executeTimeRange(ctx, qry, backend.TimeRange{From: from, To: keepFrom}, interval, true, s)
+		if err != nil {
+			return nil, hit, err
+		}
+		responses = append(responses, head)
+	}
+	if hit {
+		cached := &druidResponse{Columns: e.response.Columns}
+		tc := ds.timeColumn(e.response)
+		for _, row := range e.response.Rows {
+			//note: buckets are labelled with their start, which may be before from
+			t, ok := ds.parseTime(row[tc])
+			if ok && t.Add(align).After(keepFrom) && t.Before(fetchFrom) {
+				cached.Rows = append(cached.Rows, row)
+			}
+		}
+		responses = append(responses, cached)
+	}
+	tail := &druidResponse{}
+	if fetchFrom.Before(to) {
+		var err error
+		tail, err = ds.executeTimeRange(ctx, qry, backend.TimeRange{From: fetchFrom, To: to}, interval, false, s)
+		if err != nil {
+			return nil, hit, err
+		}
+	}
+	r := tail
+	if hit {
+		r = ds.stitchResponses(append(responses, tail))
+	}
+	if len(r.Rows) > 0 && ds.timeColumn(r) == -1 {
+		return r, hit, nil
+	}
+	//note: the cache holds its own copy of the rows as the returned ones are altered while building frames
+	s.timeCache.set(key, &timeRangeCacheEntry{
+		from:     from,
+		to:       to,
+		response: ds.stitchResponses([]*druidResponse{r}),
+		updated:  time.Now(),
+	})
+	return r, hit, nil
+}
+
+// timeColumn returns the position of the bucket time column of a response.
+func (ds *druidDatasource) timeColumn(r *druidResponse) int {
+	for i, c := range r.Columns {
+		if c.Name == "timestamp" || c.Name == "__time" {
+			return i
+		}
+	}
+	for i, c := range r.Columns {
+		if c.Type == "time" || strings.Contains(strings.ToLower(c.Name), "time") {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ds *druidDatasource) parseTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case string:
+		pt, err := time.Parse(time.RFC3339Nano, t)
+		return pt, err == nil
+	case float64:
+		sec, dec := math.Modf(t / 1000)
+		return time.Unix(int64(sec), int64(dec*(1e9))), true
+	}
+	return time.Time{}, false
+}
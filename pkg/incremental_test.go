@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// fakeHourlyBroker answers timeseries queries with one bucket per hour of
+// their interval, valued with the number of minutes of the interval in it.
+type fakeHourlyBroker struct {
+	mu        sync.Mutex
+	intervals []string
+}
+
+func (b *fakeHourlyBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var q struct {
+		Intervals []string `json:"intervals"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil || len(q.Intervals) != 1 {
+		http.Error(w, "bad query", http.StatusBadRequest)
+		return
+	}
+	b.mu.Lock()
+	b.intervals = append(b.intervals, q.Intervals[0])
+	b.mu.Unlock()
+	parts := strings.Split(q.Intervals[0], "/")
+	start, _ := time.Parse("2006-01-02T15:04:05.000Z", parts[0])
+	stop, _ := time.Parse("2006-01-02T15:04:05.000Z", parts[1])
+	var results []string
+	for t := start.Truncate(time.Hour); t.Before(stop); t = t.Add(time.Hour) {
+		from, to := t, t.Add(time.Hour)
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(stop) {
+			to = stop
+		}
+		results = append(results, fmt.Sprintf(`{"timestamp":"%s","result":{"minutes":%d}}`, t.Format("2006-01-02T15:04:05.000Z"), int(to.Sub(from).Minutes())))
+	}
+	fmt.Fprintf(w, "[%s]", strings.Join(results, ","))
+}
+
+func (b *fakeHourlyBroker) fetched() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	intervals := b.intervals
+	b.intervals = nil
+	return intervals
+}
+
+func runIncrementalQuery(t *testing.T, ds *druidDatasource, s *druidInstanceSettings, json string, from, to time.Time) (*druidResponse, bool) {
+	t.Helper()
+	qry := backend.DataQuery{TimeRange: backend.TimeRange{From: from, To: to}, JSON: []byte(json)}
+	q, settings, err := ds.prepareQuery(context.Background(), qry, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, align, ok := ds.incrementalTimeRange(q, settings, s)
+	if !ok {
+		t.Fatal("query not served incrementally")
+	}
+	r, hit, err := ds.executeIncrementalQuery(context.Background(), qry, key, align, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, hit
+}
+
+func checkMinutes(t *testing.T, r *druidResponse, first time.Time, minutes ...float64) {
+	t.Helper()
+	if len(r.Rows) != len(minutes) {
+		t.Fatalf("got %d rows %v, want %d", len(r.Rows), r.Rows, len(minutes))
+	}
+	for i, row := range r.Rows {
+		want := first.Add(time.Duration(i) * time.Hour).Format("2006-01-02T15:04:05.000Z")
+		if row[0] != want || row[1] != minutes[i] {
+			t.Errorf("row %d = %v, want [%s %v]", i, row, want, minutes[i])
+		}
+	}
+}
+
+const incrementalTimeseries = `{"builder":{"queryType":"timeseries","dataSource":"x","granularity":"hour","intervals":["x"]},"settings":{"timeRangeBinding":"replace","incrementalCache":true}}`
+
+func TestIncrementalQuery(t *testing.T) {
+	broker := &fakeHourlyBroker{}
+	srv := httptest.NewServer(broker)
+	defer srv.Close()
+	ds, s := newTestDatasource(t, srv.URL)
+	ten := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	r, hit := runIncrementalQuery(t, ds, s, incrementalTimeseries, ten.Add(17*time.Minute), ten.Add(5*time.Hour+17*time.Minute))
+	if hit {
+		t.Error("first query should miss")
+	}
+	checkMinutes(t, r, ten, 43, 60, 60, 60, 60, 17)
+	broker.fetched()
+
+	//note: the first bucket started before from and is fetched again for the new from
+	r, hit = runIncrementalQuery(t, ds, s, incrementalTimeseries, ten.Add(22*time.Minute), ten.Add(5*time.Hour+22*time.Minute))
+	if !hit {
+		t.Error("refresh should hit")
+	}
+	checkMinutes(t, r, ten, 38, 60, 60, 60, 60, 22)
+	if fetched := broker.fetched(); len(fetched) != 2 || fetched[0] != "2020-01-01T10:22:00.000Z/2020-01-01T11:00:00.000Z" || fetched[1] != "2020-01-01T15:00:00.000Z/2020-01-01T15:22:00.000Z" {
+		t.Errorf("fetched %v, want the first and last buckets", fetched)
+	}
+
+	//note: same from, the cached first bucket is kept
+	r, hit = runIncrementalQuery(t, ds, s, incrementalTimeseries, ten.Add(22*time.Minute), ten.Add(5*time.Hour+30*time.Minute))
+	if !hit {
+		t.Error("refresh should hit")
+	}
+	checkMinutes(t, r, ten, 38, 60, 60, 60, 60, 30)
+	if fetched := broker.fetched(); len(fetched) != 1 || fetched[0] != "2020-01-01T15:00:00.000Z/2020-01-01T15:30:00.000Z" {
+		t.Errorf("fetched %v, want the last bucket only", fetched)
+	}
+
+	//note: zoomed in, the cached buckets after to must not be returned
+	r, hit = runIncrementalQuery(t, ds, s, incrementalTimeseries, ten.Add(22*time.Minute), ten.Add(3*time.Hour+10*time.Minute))
+	if !hit {
+		t.Error("zoom in should hit")
+	}
+	checkMinutes(t, r, ten, 38, 60, 60, 10)
+	if fetched := broker.fetched(); len(fetched) != 1 || fetched[0] != "2020-01-01T13:00:00.000Z/2020-01-01T13:10:00.000Z" {
+		t.Errorf("fetched %v, want the bucket of to only", fetched)
+	}
+
+	//note: the zoomed in time range is the cached one now
+	r, hit = runIncrementalQuery(t, ds, s, incrementalTimeseries, ten.Add(22*time.Minute), ten.Add(3*time.Hour+20*time.Minute))
+	if !hit {
+		t.Error("refresh should hit")
+	}
+	checkMinutes(t, r, ten, 38, 60, 60, 20)
+}
+
+func TestIncrementalQueryInterpolatedIntervals(t *testing.T) {
+	srv := httptest.NewServer(&fakeHourlyBroker{})
+	defer srv.Close()
+	ds, s := newTestDatasource(t, srv.URL)
+	ten := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	//note: the intervals as interpolated by the frontend from ${__from:date:iso}/${__to:date:iso}
+	query := func(from, to time.Time) string {
+		return fmt.Sprintf(`{"builder":{"queryType":"timeseries","dataSource":"x","granularity":"hour","intervals":["%s/%s"]},"settings":{"timeRangeBinding":"intersect","incrementalCache":true}}`, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	from, to := ten.Add(17*time.Minute), ten.Add(5*time.Hour+17*time.Minute)
+	if _, hit := runIncrementalQuery(t, ds, s, query(from, to), from, to); hit {
+		t.Error("first query should miss")
+	}
+	from, to = ten.Add(22*time.Minute), ten.Add(5*time.Hour+22*time.Minute)
+	if _, hit := runIncrementalQuery(t, ds, s, query(from, to), from, to); !hit {
+		t.Error("refresh should hit")
+	}
+
+	//note: intervals restricting the time range are part of the query shape
+	restricted := `{"builder":{"queryType":"timeseries","dataSource":"x","granularity":"hour","intervals":["2020-01-01T12:00:00Z/2020-01-02"]},"settings":{"timeRangeBinding":"intersect","incrementalCache":true}}`
+	r, hit := runIncrementalQuery(t, ds, s, restricted, from, to)
+	if hit {
+		t.Error("restricted query should miss")
+	}
+	checkMinutes(t, r, ten.Add(2*time.Hour), 60, 60, 60, 22)
+}
+
+func TestIncrementalQuerySQL(t *testing.T) {
+	srv := httptest.NewServer(fakeSQLBroker{})
+	defer srv.Close()
+	ds, s := newTestDatasource(t, srv.URL)
+	ten := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	sql := `{"builder":{"queryType":"sql","query":"SELECT $__timeGroup(__time, '1h') AS __time, COUNT(*) AS \"count\" FROM t WHERE $__timeFilter(__time) GROUP BY 1"},"settings":{"incrementalCache":true}}`
+
+	r, hit := runIncrementalQuery(t, ds, s, sql, ten.Add(17*time.Minute), ten.Add(5*time.Hour+17*time.Minute))
+	if hit {
+		t.Error("first query should miss")
+	}
+	checkHourCounts(t, r, ten.Add(time.Hour), 5)
+
+	//note: the 11:00 bucket is cached, the head fetch up to it must not return it again
+	r, hit = runIncrementalQuery(t, ds, s, sql, ten.Add(22*time.Minute), ten.Add(5*time.Hour+22*time.Minute))
+	if !hit {
+		t.Error("refresh should hit")
+	}
+	checkHourCounts(t, r, ten.Add(time.Hour), 5)
+}
+
+func TestIncrementalQueryRefused(t *testing.T) {
+	ds, s := newTestDatasource(t, "http://localhost")
+	from := time.Date(2020, 1, 1, 10, 17, 0, 0, time.UTC)
+	for _, json := range []string{
+		`{"builder":{"queryType":"sql","query":"SELECT COUNT(*) FROM t WHERE $__timeFilter(__time)"},"settings":{"incrementalCache":true}}`,
+		`{"builder":{"queryType":"movingAverage","granularity":"hour"},"settings":{"timeRangeBinding":"replace","incrementalCache":true}}`,
+		`{"builder":{"queryType":"timeseries","granularity":"hour"},"settings":{"incrementalCache":true}}`,
+		`{"builder":{"queryType":"timeseries","granularity":"hour"},"settings":{"timeRangeBinding":"replace","incrementalCache":true,"contextParameters":[{"name":"grandTotal","value":true}]}}`,
+		`{"builder":{"queryType":"timeseries","granularity":"hour","descending":true},"settings":{"timeRangeBinding":"replace","incrementalCache":true}}`,
+	} {
+		qry := backend.DataQuery{TimeRange: backend.TimeRange{From: from, To: from.Add(5 * time.Hour)}, JSON: []byte(json)}
+		q, settings, err := ds.prepareQuery(context.Background(), qry, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, ok := ds.incrementalTimeRange(q, settings, s); ok {
+			t.Errorf("%s: query served incrementally", json)
+		}
+	}
+}
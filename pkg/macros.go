@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+var sqlMacroFunctionRegexp = regexp.MustCompile(`\$__(timeFilter|timeGroup|unixEpochFilter)\(([^)]*)\)`)
+
+// expandSQLMacros replaces the Grafana macros found in a SQL query text with
+// values computed from the query time range and interval. This makes the same
+// SQL usable in dashboards, explore and alert rules where no frontend
+// interpolation happens. When exclusiveStop is set, the time filters exclude
+// the stop of the time range instead of including it.
+func (ds *druidDatasource) expandSQLMacros(sql string, qry backend.DataQuery, exclusiveStop bool) (string, error) {
+	from, to := qry.TimeRange.From.UTC(), qry.TimeRange.To.UTC()
+	stopOperator := "<="
+	if exclusiveStop {
+		stopOperator = "<"
+	}
+	interval := ds.macroInterval(qry)
+	var err error
+	sql = sqlMacroFunctionRegexp.ReplaceAllStringFunc(sql, func(m string) string {
+		if err != nil {
+			return m
+		}
+		parts := sqlMacroFunctionRegexp.FindStringSubmatch(m)
+		var args []string
+		for _, a := range strings.Split(parts[2], ",") {
+			args = append(args, strings.TrimSpace(a))
+		}
+		switch parts[1] {
+		case "timeFilter":
+			if len(args) != 1 || args[0] == "" {
+				err = fmt.Errorf("macro $__timeFilter expects 1 argument, got %d", len(args))
+				return m
+			}
+			return fmt.Sprintf("%s >= %s AND %s %s %s", args[0], ds.formatSQLTimestamp(from), args[0], stopOperator, ds.formatSQLTimestamp(to))
+		case "timeGroup":
+			if len(args) != 2 {
+				err = fmt.Errorf("macro $__timeGroup expects 2 arguments, got %d", len(args))
+				return m
+			}
+			var period string
+			period, err = ds.parseMacroPeriod(args[1], interval)
+			if err != nil {
+				return m
+			}
+			return fmt.Sprintf("TIME_FLOOR(%s, '%s')", args[0], period)
+		case "unixEpochFilter":
+			if len(args) != 1 || args[0] == "" {
+				err = fmt.Errorf("macro $__unixEpochFilter expects 1 argument, got %d", len(args))
+				return m
+			}
+			return fmt.Sprintf("%s >= %d AND %s %s %d", args[0], from.Unix(), args[0], stopOperator, to.Unix())
+		}
+		return m
+	})
+	if err != nil {
+		return sql, err
+	}
+	//note: longest names first, $__interval is a prefix of $__interval_ms
+	r := strings.NewReplacer(
+		"$__interval_ms", strconv.FormatInt(int64(interval/time.Millisecond), 10),
+		"$__interval", ds.formatPeriod(interval),
+		"$__from", strconv.FormatInt(from.UnixNano()/int64(time.Millisecond), 10),
+		"$__to", strconv.FormatInt(to.UnixNano()/int64(time.Millisecond), 10),
+	)
+	return r.Replace(sql), nil
+}
+
+// macroInterval is the interval $__interval macros expand to.
+func (ds *druidDatasource) macroInterval(qry backend.DataQuery) time.Duration {
+	if interval := ds.effectiveInterval(qry); interval > 0 {
+		return interval
+	}
+	return time.Second
+}
+
+// sqlTimeGroupPeriod returns the ISO 8601 period of the first $__timeGroup
+// macro of a SQL query, if any.
+func (ds *druidDatasource) sqlTimeGroupPeriod(sql string, qry backend.DataQuery) string {
+	for _, m := range sqlMacroFunctionRegexp.FindAllStringSubmatch(sql, -1) {
+		if m[1] != "timeGroup" {
+			continue
+		}
+		args := strings.Split(m[2], ",")
+		if len(args) != 2 {
+			return ""
+		}
+		period, err := ds.parseMacroPeriod(strings.TrimSpace(args[1]), ds.macroInterval(qry))
+		if err != nil {
+			return ""
+		}
+		return period
+	}
+	return ""
+}
+
+// calendarUnits maps the Grafana interval units Go durations lack to ISO 8601
+// period designators.
+var calendarUnits = map[string]string{
+	"d": "D",
+	"w": "W",
+	"M": "M",
+	"y": "Y",
+}
+
+var calendarIntervalRegexp = regexp.MustCompile(`^(\d+)([dwMy])$`)
+
+// parseMacroPeriod accepts $__interval, a Go/Grafana duration (e.g. 5m or 1d)
+// or an ISO 8601 period (e.g. PT5M), optionally quoted, and returns an ISO 8601
+// period.
+func (ds *druidDatasource) parseMacroPeriod(arg string, interval time.Duration) (string, error) {
+	arg = strings.Trim(arg, `'"`)
+	switch {
+	case arg == "$__interval":
+		return ds.formatPeriod(interval), nil
+	case strings.HasPrefix(arg, "P"):
+		return arg, nil
+	}
+	if m := calendarIntervalRegexp.FindStringSubmatch(arg); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil || n == 0 {
+			return "", fmt.Errorf("invalid macro interval: %s", arg)
+		}
+		return "P" + m[1] + calendarUnits[m[2]], nil
+	}
+	d, err := time.ParseDuration(arg)
+	if err != nil {
+		return "", fmt.Errorf("invalid macro interval: %s", arg)
+	}
+	return ds.formatPeriod(d), nil
+}
+
+// formatPeriod formats a duration as an ISO 8601 period, e.g. PT1H30M.
+func (ds *druidDatasource) formatPeriod(d time.Duration) string {
+	ms := int64(d / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+	const day = int64(24 * time.Hour / time.Millisecond)
+	if ms%day == 0 {
+		return fmt.Sprintf("P%dD", ms/day)
+	}
+	var b strings.Builder
+	b.WriteString("PT")
+	if h := ms / int64(time.Hour/time.Millisecond); h > 0 {
+		fmt.Fprintf(&b, "%dH", h)
+		ms %= int64(time.Hour / time.Millisecond)
+	}
+	if m := ms / int64(time.Minute/time.Millisecond); m > 0 {
+		fmt.Fprintf(&b, "%dM", m)
+		ms %= int64(time.Minute / time.Millisecond)
+	}
+	if ms > 0 {
+		if ms%1000 == 0 {
+			fmt.Fprintf(&b, "%dS", ms/1000)
+		} else {
+			fmt.Fprintf(&b, "%d.%03dS", ms/1000, ms%1000)
+		}
+	}
+	return b.String()
+}
+
+func (ds *druidDatasource) formatSQLTimestamp(t time.Time) string {
+	return "TIMESTAMP '" + t.Format("2006-01-02 15:04:05.000") + "'"
+}
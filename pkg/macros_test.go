@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestParseMacroPeriod(t *testing.T) {
+	ds := &druidDatasource{}
+	tests := []struct {
+		arg    string
+		period string
+	}{
+		{"$__interval", "PT30S"},
+		{"'PT5M'", "PT5M"},
+		{"'5m'", "PT5M"},
+		{"1h30m", "PT1H30M"},
+		{"'1d'", "P1D"},
+		{"2w", "P2W"},
+		{"'1M'", "P1M"},
+		{"1y", "P1Y"},
+	}
+	for _, tt := range tests {
+		period, err := ds.parseMacroPeriod(tt.arg, 30*time.Second)
+		if err != nil {
+			t.Errorf("parseMacroPeriod(%q): %v", tt.arg, err)
+			continue
+		}
+		if period != tt.period {
+			t.Errorf("parseMacroPeriod(%q) = %q, want %q", tt.arg, period, tt.period)
+		}
+	}
+	for _, arg := range []string{"", "1x", "0d", "day"} {
+		if _, err := ds.parseMacroPeriod(arg, time.Second); err == nil {
+			t.Errorf("parseMacroPeriod(%q): expected an error", arg)
+		}
+	}
+}
+
+func TestExpandSQLMacros(t *testing.T) {
+	ds := &druidDatasource{}
+	from := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	qry := backend.DataQuery{
+		TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)},
+		Interval:  time.Minute,
+	}
+	tests := []struct {
+		sql      string
+		expanded string
+	}{
+		{
+			"SELECT $__timeGroup(__time, '1d'), COUNT(*) FROM t WHERE $__timeFilter(__time) GROUP BY 1",
+			"SELECT TIME_FLOOR(__time, 'P1D'), COUNT(*) FROM t WHERE __time >= TIMESTAMP '2020-01-01 10:00:00.000' AND __time <= TIMESTAMP '2020-01-01 11:00:00.000' GROUP BY 1",
+		},
+		{
+			"SELECT $__timeGroup(__time, $__interval) FROM t WHERE $__unixEpochFilter(ts)",
+			"SELECT TIME_FLOOR(__time, 'PT1M') FROM t WHERE ts >= 1577872800 AND ts <= 1577876400",
+		},
+		{
+			"SELECT $__interval_ms, '$__interval', $__from, $__to",
+			"SELECT 60000, 'PT1M', 1577872800000, 1577876400000",
+		},
+	}
+	for _, tt := range tests {
+		expanded, err := ds.expandSQLMacros(tt.sql, qry, false)
+		if err != nil {
+			t.Errorf("expandSQLMacros(%q): %v", tt.sql, err)
+			continue
+		}
+		if expanded != tt.expanded {
+			t.Errorf("expandSQLMacros(%q) =\n%s\nwant\n%s", tt.sql, expanded, tt.expanded)
+		}
+	}
+	//note: chunks of a split query exclude their stop, the next chunk starts there
+	sql := "WHERE $__timeFilter(__time) AND $__unixEpochFilter(ts)"
+	want := "WHERE __time >= TIMESTAMP '2020-01-01 10:00:00.000' AND __time < TIMESTAMP '2020-01-01 11:00:00.000' AND ts >= 1577872800 AND ts < 1577876400"
+	if expanded, err := ds.expandSQLMacros(sql, qry, true); err != nil || expanded != want {
+		t.Errorf("expandSQLMacros(%q) with an exclusive stop =\n%s, %v\nwant\n%s", sql, expanded, err, want)
+	}
+	for _, sql := range []string{"$__timeFilter()", "$__timeGroup(__time)", "$__timeGroup(__time, '1x')"} {
+		if _, err := ds.expandSQLMacros(sql, qry, false); err == nil {
+			t.Errorf("expandSQLMacros(%q): expected an error", sql)
+		}
+	}
+}
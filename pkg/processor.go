@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafadruid/go-druid"
+)
+
+// QueryProcessor handles one Druid query type: how the query is prepared
+// before it is sent, how it is executed and how its response is decoded.
+type QueryProcessor interface {
+	// Prepare adapts the query builder before the query is sent to Druid.
+	Prepare(builder map[string]interface{}, settings map[string]interface{}) error
+	// Endpoint is the broker path the query is posted to, and cancelled from.
+	Endpoint() string
+	// QueryIDKey is the query context key holding the id of the query.
+	QueryIDKey() string
+	// Execute runs the prepared query and returns the raw Druid response. It
+	// is called once for identical concurrent queries and its result may be
+	// cached.
+	Execute(ctx context.Context, q *druidPreparedQuery, s *druidInstanceSettings) (json.RawMessage, error)
+	// Decode turns the raw Druid response into rows and typed columns.
+	Decode(q *druidPreparedQuery, settings map[string]interface{}, result json.RawMessage) (*druidResponse, error)
+}
+
+// RegisterQueryProcessor makes the datasource handle queries of the given type
+// with p, replacing any processor already registered for that type.
+func (ds *druidDatasource) RegisterQueryProcessor(queryType string, p QueryProcessor) {
+	ds.processors[queryType] = p
+}
+
+func (ds *druidDatasource) registerQueryProcessors() {
+	ds.RegisterQueryProcessor("sql", &sqlQueryProcessor{ds: ds})
+	ds.RegisterQueryProcessor("timeseries", &timeseriesQueryProcessor{nativeQueryProcessor{ds: ds}})
+	ds.RegisterQueryProcessor("topN", &topNQueryProcessor{nativeQueryProcessor{ds: ds}})
+	ds.RegisterQueryProcessor("groupBy", &groupByQueryProcessor{nativeQueryProcessor{ds: ds}})
+	ds.RegisterQueryProcessor("scan", &scanQueryProcessor{nativeQueryProcessor{ds: ds}})
+	ds.RegisterQueryProcessor("search", &searchQueryProcessor{nativeQueryProcessor{ds: ds}})
+	ds.RegisterQueryProcessor("timeBoundary", &timeBoundaryQueryProcessor{nativeQueryProcessor{ds: ds}})
+	ds.RegisterQueryProcessor("dataSourceMetadata", &dataSourceMetadataQueryProcessor{nativeQueryProcessor{ds: ds}})
+	ds.RegisterQueryProcessor("segmentMetadata", &segmentMetadataQueryProcessor{nativeQueryProcessor{ds: ds}})
+	ds.RegisterQueryProcessor("movingAverage", &movingAverageQueryProcessor{nativeQueryProcessor{ds: ds}})
+}
+
+// nativeQueryProcessor holds what native query types have in common. Query
+// processors of native query types embed it and implement Decode.
+type nativeQueryProcessor struct {
+	ds *druidDatasource
+}
+
+func (p *nativeQueryProcessor) Prepare(builder map[string]interface{}, settings map[string]interface{}) error {
+	return nil
+}
+
+func (p *nativeQueryProcessor) Endpoint() string {
+	return druid.NativeQueryEndpoint
+}
+
+func (p *nativeQueryProcessor) QueryIDKey() string {
+	return "queryId"
+}
+
+func (p *nativeQueryProcessor) Execute(ctx context.Context, q *druidPreparedQuery, s *druidInstanceSettings) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := p.ds.doQuery(ctx, q, s, &result)
+	return result, err
+}
+
+// decodeResultObjects decodes the responses made of one {timestamp, field}
+// object per time bucket, field being an object of values.
+func (p *nativeQueryProcessor) decodeResultObjects(q *druidPreparedQuery, result json.RawMessage, field string) (*druidResponse, error) {
+	r := &druidResponse{}
+	d := &responseDecoder{queryType: q.Type()}
+	results, err := d.results(result)
+	if err != nil {
+		return r, err
+	}
+	columns := []string{"timestamp"}
+	for i, res := range results {
+		path := fmt.Sprintf("$[%d]", i)
+		o, err := d.object(path, res)
+		if err != nil {
+			return r, err
+		}
+		values, err := d.object(path+"."+field, o[field])
+		if err != nil {
+			return r, err
+		}
+		if i == 0 {
+			columns = append(columns, p.ds.orderedKeys(values, q.columnOrder)...)
+		}
+		t := o["timestamp"]
+		if t == nil && len(r.Rows) > 0 {
+			//grand total, lets keep it last
+			t = r.Rows[len(r.Rows)-1][0]
+		}
+		row := []interface{}{t}
+		for _, c := range columns[1:] {
+			row = append(row, values[c])
+		}
+		r.Rows = append(r.Rows, row)
+	}
+	r.Columns = p.ds.typeColumns(columns, r.Rows, q.columnTypes)
+	return r, nil
+}
+
+// decodeResultArrays decodes the responses made of one {timestamp, result}
+// object per time bucket, result being an array of objects of values.
+func (p *nativeQueryProcessor) decodeResultArrays(q *druidPreparedQuery, result json.RawMessage) (*druidResponse, error) {
+	r := &druidResponse{}
+	d := &responseDecoder{queryType: q.Type()}
+	results, err := d.results(result)
+	if err != nil {
+		return r, err
+	}
+	var columns []string
+	for i, res := range results {
+		path := fmt.Sprintf("$[%d]", i)
+		o, err := d.object(path, res)
+		if err != nil {
+			return r, err
+		}
+		records, err := d.array(path+".result", o["result"])
+		if err != nil {
+			return r, err
+		}
+		for j, record := range records {
+			values, err := d.object(fmt.Sprintf("%s.result[%d]", path, j), record)
+			if err != nil {
+				return r, err
+			}
+			if columns == nil {
+				columns = append([]string{"timestamp"}, p.ds.orderedKeys(values, q.columnOrder)...)
+			}
+			row := []interface{}{o["timestamp"]}
+			for _, c := range columns[1:] {
+				row = append(row, values[c])
+			}
+			r.Rows = append(r.Rows, row)
+		}
+	}
+	r.Columns = p.ds.typeColumns(columns, r.Rows, q.columnTypes)
+	return r, nil
+}
@@ -0,0 +1,11 @@
+package main
+
+import "encoding/json"
+
+type dataSourceMetadataQueryProcessor struct {
+	nativeQueryProcessor
+}
+
+func (p *dataSourceMetadataQueryProcessor) Decode(q *druidPreparedQuery, settings map[string]interface{}, result json.RawMessage) (*druidResponse, error) {
+	return p.decodeResultObjects(q, result, "result")
+}
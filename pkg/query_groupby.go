@@ -0,0 +1,11 @@
+package main
+
+import "encoding/json"
+
+type groupByQueryProcessor struct {
+	nativeQueryProcessor
+}
+
+func (p *groupByQueryProcessor) Decode(q *druidPreparedQuery, settings map[string]interface{}, result json.RawMessage) (*druidResponse, error) {
+	return p.decodeResultObjects(q, result, "event")
+}
@@ -0,0 +1,14 @@
+package main
+
+import "encoding/json"
+
+// movingAverageQueryProcessor handles the query type of the Druid moving
+// average query extension (druid-moving-average-query). Its results are shaped
+// like groupBy ones, averager outputs being part of the events.
+type movingAverageQueryProcessor struct {
+	nativeQueryProcessor
+}
+
+func (p *movingAverageQueryProcessor) Decode(q *druidPreparedQuery, settings map[string]interface{}, result json.RawMessage) (*druidResponse, error) {
+	return p.decodeResultObjects(q, result, "event")
+}
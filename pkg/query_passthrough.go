@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// passthroughQueryProcessor handles the native query types no processor is
+// registered for, e.g. the ones of newer Druid versions or of extensions. The
+// query is sent as is and its response is flattened on a best effort basis:
+// arrays of objects become rows, {timestamp, event} and {timestamp, result}
+// envelopes are unwrapped, the enclosing timestamp being kept on each row, and
+// nested objects become dotted columns. The resultPath setting selects the
+// part of the response to tabulate, e.g. result.events.
+type passthroughQueryProcessor struct {
+	nativeQueryProcessor
+}
+
+func (p *passthroughQueryProcessor) Decode(q *druidPreparedQuery, settings map[string]interface{}, result json.RawMessage) (*druidResponse, error) {
+	r := &druidResponse{}
+	d := &responseDecoder{queryType: q.Type()}
+	var v interface{}
+	if err := json.Unmarshal(result, &v); err != nil {
+		return r, fmt.Errorf("invalid %s response: %w", d.queryType, err)
+	}
+	var segments []string
+	if resultPath, _ := settings["resultPath"].(string); resultPath != "" {
+		segments = strings.Split(strings.TrimPrefix(strings.TrimPrefix(resultPath, "$"), "."), ".")
+	}
+	records, err := p.walk(d, "$", v, segments, nil)
+	if err != nil {
+		return r, err
+	}
+	var columns []string
+	positions := make(map[string]int)
+	for _, record := range records {
+		if _, ok := record["timestamp"]; ok && len(columns) == 0 {
+			positions["timestamp"] = 0
+			columns = append(columns, "timestamp")
+		}
+		for _, c := range p.ds.orderedKeys(record, q.columnOrder) {
+			if _, ok := positions[c]; !ok {
+				positions[c] = len(columns)
+				columns = append(columns, c)
+			}
+		}
+	}
+	if pos, ok := positions["timestamp"]; ok && pos != 0 {
+		copy(columns[1:pos+1], columns[:pos])
+		columns[0] = "timestamp"
+	}
+	for _, record := range records {
+		row := make([]interface{}, len(columns))
+		for i, c := range columns {
+			row[i] = record[c]
+		}
+		r.Rows = append(r.Rows, row)
+	}
+	r.Columns = p.ds.typeColumns(columns, r.Rows, q.columnTypes)
+	return r, nil
+}
+
+// walk walks down the response along the path segments and flattens what it
+// points to. Arrays met on the way are walked element by element unless the
+// segment is an index, and the timestamps of the objects passed through are
+// inherited by the records.
+func (p *passthroughQueryProcessor) walk(d *responseDecoder, path string, v interface{}, segments []string, inherited map[string]interface{}) ([]map[string]interface{}, error) {
+	if len(segments) == 0 {
+		return p.flatten(v, inherited), nil
+	}
+	if a, ok := v.([]interface{}); ok {
+		if i, err := strconv.Atoi(segments[0]); err == nil {
+			if i < 0 || i >= len(a) {
+				return nil, fmt.Errorf("unexpected %s response at %s: index %d out of range", d.queryType, path, i)
+			}
+			return p.walk(d, fmt.Sprintf("%s[%d]", path, i), a[i], segments[1:], inherited)
+		}
+		var records []map[string]interface{}
+		for i, e := range a {
+			rr, err := p.walk(d, fmt.Sprintf("%s[%d]", path, i), e, segments, inherited)
+			if err != nil {
+				return nil, err
+			}
+			records = append(records, rr...)
+		}
+		return records, nil
+	}
+	o, err := d.object(path, v)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := o["timestamp"]; ok {
+		inherited = p.inherit(inherited, t)
+	}
+	return p.walk(d, path+"."+segments[0], o[segments[0]], segments[1:], inherited)
+}
+
+// flatten turns a part of a response into records.
+func (p *passthroughQueryProcessor) flatten(v interface{}, inherited map[string]interface{}) []map[string]interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		var records []map[string]interface{}
+		for _, e := range v {
+			records = append(records, p.flatten(e, inherited)...)
+		}
+		return records
+	case map[string]interface{}:
+		t, hasTimestamp := v["timestamp"]
+		if hasTimestamp {
+			inherited = p.inherit(inherited, t)
+		}
+		for _, envelope := range []string{"event", "result"} {
+			switch e := v[envelope].(type) {
+			case map[string]interface{}:
+				if hasTimestamp {
+					return []map[string]interface{}{p.record(e, inherited)}
+				}
+			case []interface{}:
+				if hasTimestamp {
+					return p.flatten(e, inherited)
+				}
+			}
+		}
+		return []map[string]interface{}{p.record(v, inherited)}
+	case nil:
+		return nil
+	}
+	return []map[string]interface{}{p.record(map[string]interface{}{"value": v}, inherited)}
+}
+
+// record makes a record of an object, nested objects becoming dotted columns.
+func (p *passthroughQueryProcessor) record(o map[string]interface{}, inherited map[string]interface{}) map[string]interface{} {
+	record := make(map[string]interface{}, len(inherited)+len(o))
+	for k, v := range inherited {
+		record[k] = v
+	}
+	var flattenObject func(prefix string, o map[string]interface{})
+	flattenObject = func(prefix string, o map[string]interface{}) {
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if nested, ok := o[k].(map[string]interface{}); ok && len(nested) > 0 {
+				flattenObject(prefix+k+".", nested)
+				continue
+			}
+			record[prefix+k] = o[k]
+		}
+	}
+	flattenObject("", o)
+	return record
+}
+
+func (p *passthroughQueryProcessor) inherit(inherited map[string]interface{}, timestamp interface{}) map[string]interface{} {
+	i := make(map[string]interface{}, len(inherited)+1)
+	for k, v := range inherited {
+		i[k] = v
+	}
+	i["timestamp"] = timestamp
+	return i
+}
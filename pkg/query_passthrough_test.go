@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestPassthroughDecode(t *testing.T) {
+	ds := &druidDatasource{}
+	p := &passthroughQueryProcessor{nativeQueryProcessor{ds: ds}}
+	q := &druidPreparedQuery{queryType: "someExtension"}
+	tests := []struct {
+		name       string
+		resultPath string
+		result     string
+		columns    []string
+		rows       [][]interface{}
+	}{
+		{
+			"result envelopes",
+			"",
+			`[{"timestamp":"t1","result":{"a":1,"nested":{"b":2}}},{"timestamp":"t2","result":[{"a":3},{"a":4}]}]`,
+			[]string{"timestamp", "a", "nested.b"},
+			[][]interface{}{{"t1", 1.0, 2.0}, {"t2", 3.0, nil}, {"t2", 4.0, nil}},
+		},
+		{
+			"event envelopes",
+			"",
+			`[{"version":"v1","timestamp":"t1","event":{"x":"a"}}]`,
+			[]string{"timestamp", "x"},
+			[][]interface{}{{"t1", "a"}},
+		},
+		{
+			"plain objects",
+			"",
+			`[{"name":"a","size":1},{"name":"b","extra":true}]`,
+			[]string{"name", "size", "extra"},
+			[][]interface{}{{"a", 1.0, nil}, {"b", nil, true}},
+		},
+		{
+			"scalars",
+			"",
+			`["a","b"]`,
+			[]string{"value"},
+			[][]interface{}{{"a"}, {"b"}},
+		},
+		{
+			"result path",
+			"$.result.events",
+			`{"timestamp":"t0","result":{"events":[{"x":1},{"x":2}]}}`,
+			[]string{"timestamp", "x"},
+			[][]interface{}{{"t0", 1.0}, {"t0", 2.0}},
+		},
+		{
+			"result path through arrays",
+			"result.1",
+			`[{"timestamp":"t1","result":[{"x":1},{"x":2}]},{"timestamp":"t2","result":[{"x":3},{"x":4}]}]`,
+			[]string{"timestamp", "x"},
+			[][]interface{}{{"t1", 2.0}, {"t2", 4.0}},
+		},
+	}
+	for _, tt := range tests {
+		r, err := p.Decode(q, map[string]interface{}{"resultPath": tt.resultPath}, json.RawMessage(tt.result))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var columns []string
+		for _, c := range r.Columns {
+			columns = append(columns, c.Name)
+		}
+		if !reflect.DeepEqual(columns, tt.columns) {
+			t.Errorf("%s: columns = %v, want %v", tt.name, columns, tt.columns)
+		}
+		if !reflect.DeepEqual(r.Rows, tt.rows) {
+			t.Errorf("%s: rows = %v, want %v", tt.name, r.Rows, tt.rows)
+		}
+	}
+	for _, tt := range []struct{ resultPath, result string }{
+		{"", `{`},
+		{"result.5", `[{"result":[1]}]`},
+		{"result.events", `[{"result":"x"}]`},
+	} {
+		if _, err := p.Decode(q, map[string]interface{}{"resultPath": tt.resultPath}, json.RawMessage(tt.result)); err == nil {
+			t.Errorf("Decode(%s) with result path %q: expected an error", tt.result, tt.resultPath)
+		}
+	}
+}
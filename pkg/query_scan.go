@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const defaultScanRowLimit = 100000
+
+type scanQueryProcessor struct {
+	nativeQueryProcessor
+}
+
+func (p *scanQueryProcessor) Prepare(builder map[string]interface{}, settings map[string]interface{}) error {
+	if builder["resultFormat"] != "list" {
+		builder["resultFormat"] = "compactedList"
+	}
+	//note: one more row than the cap tells whether the results were truncated
+	rowLimit := p.rowLimit(settings)
+	if limit, _ := builder["limit"].(float64); limit <= 0 || int(limit) > rowLimit {
+		builder["limit"] = rowLimit + 1
+	}
+	return nil
+}
+
+func (p *scanQueryProcessor) Decode(q *druidPreparedQuery, settings map[string]interface{}, result json.RawMessage) (*druidResponse, error) {
+	r := &druidResponse{}
+	d := &responseDecoder{queryType: q.Type()}
+	results, err := d.results(result)
+	if err != nil {
+		return r, err
+	}
+	//note: druid returns one batch per segment, columns may differ from one batch to another
+	rowLimit := p.rowLimit(settings)
+	var columns []string
+	positions := make(map[string]int)
+	position := func(c string) int {
+		pos, ok := positions[c]
+		if !ok {
+			pos = len(columns)
+			positions[c] = pos
+			columns = append(columns, c)
+		}
+		return pos
+	}
+batches:
+	for i, res := range results {
+		path := fmt.Sprintf("$[%d]", i)
+		batch, err := d.object(path, res)
+		if err != nil {
+			return r, err
+		}
+		var batchPositions []int
+		if batchColumns, ok := batch["columns"]; ok {
+			cc, err := d.array(path+".columns", batchColumns)
+			if err != nil {
+				return r, err
+			}
+			for j, c := range cc {
+				name, err := d.string(fmt.Sprintf("%s.columns[%d]", path, j), c)
+				if err != nil {
+					return r, err
+				}
+				batchPositions = append(batchPositions, position(name))
+			}
+		}
+		events, err := d.array(path+".events", batch["events"])
+		if err != nil {
+			return r, err
+		}
+		for j, e := range events {
+			if len(r.Rows) >= rowLimit {
+				r.Notices = append(r.Notices, data.Notice{
+					Severity: data.NoticeSeverityWarning,
+					Text:     fmt.Sprintf("Scan results have been truncated to %d rows", rowLimit),
+				})
+				break batches
+			}
+			row := make([]interface{}, len(columns))
+			switch event := e.(type) {
+			case []interface{}:
+				for k, v := range event {
+					if k < len(batchPositions) {
+						row[batchPositions[k]] = v
+					}
+				}
+			case map[string]interface{}:
+				for _, c := range p.ds.orderedKeys(event, nil) {
+					pos := position(c)
+					for len(row) <= pos {
+						row = append(row, nil)
+					}
+					row[pos] = event[c]
+				}
+			default:
+				return r, d.error(fmt.Sprintf("%s.events[%d]", path, j), "array or object", e)
+			}
+			r.Rows = append(r.Rows, row)
+		}
+	}
+	for i := range r.Rows {
+		for len(r.Rows[i]) < len(columns) {
+			r.Rows[i] = append(r.Rows[i], nil)
+		}
+	}
+	r.Columns = p.ds.typeColumns(columns, r.Rows, q.columnTypes)
+	return r, nil
+}
+
+// rowLimit is the maximum number of rows a scan query returns, from the
+// scanRowLimit setting.
+func (p *scanQueryProcessor) rowLimit(settings map[string]interface{}) int {
+	if limit, ok := settings["scanRowLimit"].(float64); ok && limit > 0 {
+		return int(limit)
+	}
+	return defaultScanRowLimit
+}
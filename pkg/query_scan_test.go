@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestScanDecode(t *testing.T) {
+	ds := &druidDatasource{}
+	p := &scanQueryProcessor{nativeQueryProcessor{ds: ds}}
+	q := &druidPreparedQuery{queryType: "scan"}
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		result   string
+		columns  []string
+		rows     [][]interface{}
+		notices  int
+	}{
+		{
+			"batches with different columns",
+			nil,
+			`[{"segmentId":"s1","columns":["__time","a"],"events":[[1.0,"x"],[2.0,"y"]]},{"segmentId":"s2","columns":["__time","b","a"],"events":[[3.0,true,"z"]]}]`,
+			[]string{"__time", "a", "b"},
+			[][]interface{}{{1.0, "x", nil}, {2.0, "y", nil}, {3.0, "z", true}},
+			0,
+		},
+		{
+			"list batches",
+			nil,
+			`[{"events":[{"__time":1.0,"a":"x"}]},{"events":[{"b":2.0,"__time":2.0}]}]`,
+			[]string{"__time", "a", "b"},
+			[][]interface{}{{1.0, "x", nil}, {2.0, nil, 2.0}},
+			0,
+		},
+		{
+			"row cap",
+			map[string]interface{}{"scanRowLimit": 2.0},
+			`[{"columns":["a"],"events":[["x"]]},{"columns":["a"],"events":[["y"],["z"]]}]`,
+			[]string{"a"},
+			[][]interface{}{{"x"}, {"y"}},
+			1,
+		},
+		{
+			"no batch",
+			nil,
+			`[]`,
+			nil,
+			nil,
+			0,
+		},
+	}
+	for _, tt := range tests {
+		r, err := p.Decode(q, tt.settings, json.RawMessage(tt.result))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var columns []string
+		for _, c := range r.Columns {
+			columns = append(columns, c.Name)
+		}
+		if !reflect.DeepEqual(columns, tt.columns) {
+			t.Errorf("%s: columns = %v, want %v", tt.name, columns, tt.columns)
+		}
+		if !reflect.DeepEqual(r.Rows, tt.rows) {
+			t.Errorf("%s: rows = %v, want %v", tt.name, r.Rows, tt.rows)
+		}
+		if len(r.Notices) != tt.notices {
+			t.Errorf("%s: notices = %v, want %d", tt.name, r.Notices, tt.notices)
+		}
+	}
+	for _, result := range []string{`{}`, `[{"columns":["a"],"events":{}}]`, `[{"columns":[1],"events":[]}]`, `[{"events":["x"]}]`} {
+		_, err := p.Decode(q, nil, json.RawMessage(result))
+		var de *decodeError
+		if !errors.As(err, &de) {
+			t.Errorf("Decode(%s): got %v, want a decode error", result, err)
+		}
+	}
+}
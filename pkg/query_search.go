@@ -0,0 +1,11 @@
+package main
+
+import "encoding/json"
+
+type searchQueryProcessor struct {
+	nativeQueryProcessor
+}
+
+func (p *searchQueryProcessor) Decode(q *druidPreparedQuery, settings map[string]interface{}, result json.RawMessage) (*druidResponse, error) {
+	return p.decodeResultArrays(q, result)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type segmentMetadataQueryProcessor struct {
+	nativeQueryProcessor
+}
+
+// Decode tabulates the part of the segment metadata selected by the view
+// setting: base, aggregators, columns or timestampspec.
+func (p *segmentMetadataQueryProcessor) Decode(q *druidPreparedQuery, settings map[string]interface{}, result json.RawMessage) (*druidResponse, error) {
+	r := &druidResponse{}
+	d := &responseDecoder{queryType: q.Type()}
+	results, err := d.results(result)
+	if err != nil || len(results) == 0 {
+		return r, err
+	}
+	var columns []string
+	view, _ := settings["view"].(string)
+	switch view {
+	case "base", "":
+		first, err := d.object("$[0]", results[0])
+		if err != nil {
+			return r, err
+		}
+		for _, k := range p.ds.orderedKeys(first, nil) {
+			switch k {
+			case "aggregators", "columns", "timestampSpec":
+			case "intervals":
+				intervals, err := d.array("$[0].intervals", first[k])
+				if err != nil {
+					return r, err
+				}
+				for i := range intervals {
+					pos := strconv.Itoa(i)
+					columns = append(columns, "interval_start_"+pos, "interval_stop_"+pos)
+				}
+			default:
+				columns = append(columns, k)
+			}
+		}
+		for i, res := range results {
+			path := fmt.Sprintf("$[%d]", i)
+			o, err := d.object(path, res)
+			if err != nil {
+				return r, err
+			}
+			var intervals []interface{}
+			if o["intervals"] != nil {
+				intervals, err = d.array(path+".intervals", o["intervals"])
+				if err != nil {
+					return r, err
+				}
+			}
+			var row []interface{}
+			for _, c := range columns {
+				var col interface{}
+				if strings.HasPrefix(c, "interval_") {
+					parts := strings.Split(c, "_")
+					pos := 0
+					if parts[1] == "stop" {
+						pos = 1
+					}
+					idx, err := strconv.Atoi(parts[2])
+					if err != nil {
+						return r, errors.New("interval parsing goes wrong")
+					}
+					if idx < len(intervals) {
+						ipath := fmt.Sprintf("%s.intervals[%d]", path, idx)
+						interval, err := d.string(ipath, intervals[idx])
+						if err != nil {
+							return r, err
+						}
+						bounds := strings.Split(interval, "/")
+						if len(bounds) != 2 {
+							return r, &decodeError{queryType: d.queryType, path: ipath, expected: "start/stop interval", actual: interval}
+						}
+						col = bounds[pos]
+					}
+				} else {
+					col = o[c]
+				}
+				row = append(row, col)
+			}
+			r.Rows = append(r.Rows, row)
+		}
+	case "aggregators", "columns":
+		field, name := view, "aggregator"
+		if view == "columns" {
+			name = "column"
+		}
+		for i, res := range results {
+			path := fmt.Sprintf("$[%d]", i)
+			o, err := d.object(path, res)
+			if err != nil {
+				return r, err
+			}
+			entries, err := d.object(path+"."+field, o[field])
+			if err != nil {
+				return r, err
+			}
+			for _, k := range p.ds.orderedKeys(entries, nil) {
+				entry, err := d.object(path+"."+field+"."+k, entries[k])
+				if err != nil {
+					return r, err
+				}
+				if columns == nil {
+					columns = append([]string{name}, p.ds.orderedKeys(entry, nil)...)
+				}
+				row := []interface{}{k}
+				for _, c := range columns[1:] {
+					row = append(row, entry[c])
+				}
+				r.Rows = append(r.Rows, row)
+			}
+		}
+	case "timestampspec":
+		for i, res := range results {
+			path := fmt.Sprintf("$[%d]", i)
+			o, err := d.object(path, res)
+			if err != nil {
+				return r, err
+			}
+			spec, err := d.object(path+".timestampSpec", o["timestampSpec"])
+			if err != nil {
+				return r, err
+			}
+			if i == 0 {
+				columns = p.ds.orderedKeys(spec, nil)
+			}
+			var row []interface{}
+			for _, c := range columns {
+				row = append(row, spec[c])
+			}
+			r.Rows = append(r.Rows, row)
+		}
+	default:
+		return r, fmt.Errorf("unknown segmentMetadata view: %s", view)
+	}
+	r.Columns = p.ds.typeColumns(columns, r.Rows, q.columnTypes)
+	return r, nil
+}
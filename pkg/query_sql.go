@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/grafadruid/go-druid"
+)
+
+type sqlQueryProcessor struct {
+	ds *druidDatasource
+}
+
+func (p *sqlQueryProcessor) Prepare(builder map[string]interface{}, settings map[string]interface{}) error {
+	builder["resultFormat"] = "array"
+	builder["header"] = true
+	builder["typesHeader"] = true
+	builder["sqlTypesHeader"] = true
+	return nil
+}
+
+func (p *sqlQueryProcessor) Endpoint() string {
+	return druid.SQLQueryEndpoint
+}
+
+func (p *sqlQueryProcessor) QueryIDKey() string {
+	return "sqlQueryId"
+}
+
+func (p *sqlQueryProcessor) Execute(ctx context.Context, q *druidPreparedQuery, s *druidInstanceSettings) (json.RawMessage, error) {
+	var result json.RawMessage
+	err := p.ds.doQuery(ctx, q, s, &result)
+	return result, err
+}
+
+func (p *sqlQueryProcessor) Decode(q *druidPreparedQuery, settings map[string]interface{}, result json.RawMessage) (*druidResponse, error) {
+	r := &druidResponse{}
+	d := &responseDecoder{queryType: q.Type()}
+	results, err := d.results(result)
+	if err != nil || len(results) == 0 {
+		return r, err
+	}
+	header, err := d.array("$[0]", results[0])
+	if err != nil {
+		return r, err
+	}
+	var columns []string
+	for i, c := range header {
+		name, err := d.string(fmt.Sprintf("$[0][%d]", i), c)
+		if err != nil {
+			return r, err
+		}
+		columns = append(columns, name)
+	}
+	//note: brokers older than 0.21 ignore typesHeader and sqlTypesHeader, types are then detected from values
+	sqlTypes, typed := p.typesHeader(results)
+	first := 1
+	if typed {
+		first = 3
+	}
+	for i := first; i < len(results); i++ {
+		path := fmt.Sprintf("$[%d]", i)
+		row, err := d.array(path, results[i])
+		if err != nil {
+			return r, err
+		}
+		if len(row) != len(columns) {
+			return r, &decodeError{queryType: d.queryType, path: path, expected: fmt.Sprintf("%d values", len(columns)), actual: fmt.Sprintf("%d values", len(row))}
+		}
+		r.Rows = append(r.Rows, row)
+	}
+	columnTypes := q.columnTypes
+	if typed {
+		//note: columns of null or unknown SQL types are typed from their values
+		columnTypes = make(map[string]string)
+		for i, c := range columns {
+			if t := p.columnType(sqlTypes[i]); t != "" {
+				columnTypes[c] = t
+			}
+		}
+	}
+	r.Columns = p.ds.typeColumns(columns, r.Rows, columnTypes)
+	return r, nil
+}
+
+// typesHeader returns the SQL types of an array formatted SQL response when
+// the broker sent the types headers: a row of native types, e.g. LONG or
+// COMPLEX<hyperUnique>, then a row of SQL type names. The headers are told
+// apart from data rows by their shape: native type names or nulls only, then
+// known SQL type names only. NULL types are returned empty, their columns are
+// typed from their values.
+func (p *sqlQueryProcessor) typesHeader(sqlr []interface{}) ([]string, bool) {
+	if len(sqlr) < 3 {
+		return nil, false
+	}
+	names, _ := sqlr[0].([]interface{})
+	if !p.typesRow(sqlr[1], len(names), p.nativeType) || !p.typesRow(sqlr[2], len(names), p.sqlType) {
+		return nil, false
+	}
+	types := make([]string, len(names))
+	for i, t := range sqlr[2].([]interface{}) {
+		if st, _ := t.(string); p.columnType(st) != "" {
+			types[i] = st
+		}
+	}
+	return types, true
+}
+
+var nativeTypeNameRegexp = regexp.MustCompile(`^(LONG|FLOAT|DOUBLE|STRING|COMPLEX<.+>|ARRAY<.+>)$`)
+
+// typesRow tells whether a row of an array formatted SQL response is made of n
+// type names accepted by isType.
+func (p *sqlQueryProcessor) typesRow(row interface{}, n int, isType func(v interface{}) bool) bool {
+	values, ok := row.([]interface{})
+	if !ok || len(values) != n {
+		return false
+	}
+	for _, v := range values {
+		if !isType(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// nativeType tells whether a types header value is a Druid native type, or
+// null for the columns whose type the broker doesn't know.
+func (p *sqlQueryProcessor) nativeType(v interface{}) bool {
+	t, ok := v.(string)
+	return v == nil || ok && nativeTypeNameRegexp.MatchString(t)
+}
+
+// sqlType tells whether a SQL types header value is a known Druid SQL type.
+func (p *sqlQueryProcessor) sqlType(v interface{}) bool {
+	t, _ := v.(string)
+	return t == "NULL" || p.columnType(t) != ""
+}
+
+// columnType maps a Druid SQL type to a column type.
+func (p *sqlQueryProcessor) columnType(sqlType string) string {
+	switch {
+	case strings.HasPrefix(sqlType, "COMPLEX<"), strings.HasSuffix(sqlType, "ARRAY"), strings.HasPrefix(sqlType, "ARRAY<"):
+		return "json"
+	}
+	switch sqlType {
+	case "BIGINT", "INTEGER", "SMALLINT", "TINYINT":
+		return "int"
+	case "DOUBLE", "FLOAT", "REAL", "DECIMAL":
+		return "float"
+	case "VARCHAR", "CHAR":
+		return "string"
+	case "TIMESTAMP", "DATE":
+		return "time"
+	case "BOOLEAN":
+		return "bool"
+	case "OTHER":
+		return "json"
+	}
+	return ""
+}
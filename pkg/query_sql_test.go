@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSQLDecode(t *testing.T) {
+	ds := &druidDatasource{}
+	p := &sqlQueryProcessor{ds: ds}
+	q := &druidPreparedQuery{queryType: "sql"}
+	tests := []struct {
+		name    string
+		result  string
+		columns []druidColumn
+		rows    int
+	}{
+		{
+			"types headers",
+			`[["__time","count","users"],["LONG","LONG","COMPLEX<hyperUnique>"],["TIMESTAMP","BIGINT","OTHER"],["2020-01-01T00:00:00.000Z",1,2.5]]`,
+			[]druidColumn{{"__time", "time"}, {"count", "int"}, {"users", "json"}},
+			1,
+		},
+		{
+			"null types",
+			`[["__time","x"],["LONG",null],["TIMESTAMP",null],["2020-01-01T00:00:00.000Z",1.5]]`,
+			[]druidColumn{{"__time", "time"}, {"x", "float"}},
+			1,
+		},
+		{
+			"all null types",
+			`[["x","y"],[null,null],["NULL","NULL"],[1.5,"a"]]`,
+			[]druidColumn{{"x", "float"}, {"y", "string"}},
+			1,
+		},
+		{
+			"null sql type",
+			`[["__time","v"],["LONG","STRING"],["TIMESTAMP","NULL"],["2020-01-01T00:00:00.000Z","a"]]`,
+			[]druidColumn{{"__time", "time"}, {"v", "string"}},
+			1,
+		},
+		{
+			"no types headers",
+			`[["__time","name"],["2020-01-01T00:00:00.000Z","a"],["2020-01-01T01:00:00.000Z","b"],["2020-01-01T02:00:00.000Z","c"]]`,
+			[]druidColumn{{"__time", "time"}, {"name", "string"}},
+			3,
+		},
+		{
+			"uppercase strings without types headers",
+			`[["country"],["US"],["FR"],["DE"]]`,
+			[]druidColumn{{"country", "string"}},
+			3,
+		},
+		{
+			"nulls without types headers",
+			`[["x"],[null],[null],[1.5]]`,
+			[]druidColumn{{"x", "float"}},
+			3,
+		},
+		{
+			"unknown sql type without types headers",
+			`[["v"],["LONG"],["SOME_NEW_TYPE"],["a"]]`,
+			[]druidColumn{{"v", "string"}},
+			3,
+		},
+	}
+	for _, tt := range tests {
+		r, err := p.Decode(q, nil, json.RawMessage(tt.result))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(r.Rows) != tt.rows {
+			t.Errorf("%s: got %d rows %v, want %d", tt.name, len(r.Rows), r.Rows, tt.rows)
+		}
+		if len(r.Columns) != len(tt.columns) {
+			t.Errorf("%s: columns = %v, want %v", tt.name, r.Columns, tt.columns)
+			continue
+		}
+		for i, c := range tt.columns {
+			if r.Columns[i] != c {
+				t.Errorf("%s: column %d = %v, want %v", tt.name, i, r.Columns[i], c)
+			}
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import "encoding/json"
+
+type timeseriesQueryProcessor struct {
+	nativeQueryProcessor
+}
+
+func (p *timeseriesQueryProcessor) Decode(q *druidPreparedQuery, settings map[string]interface{}, result json.RawMessage) (*druidResponse, error) {
+	return p.decodeResultObjects(q, result, "result")
+}
@@ -0,0 +1,11 @@
+package main
+
+import "encoding/json"
+
+type topNQueryProcessor struct {
+	nativeQueryProcessor
+}
+
+func (p *topNQueryProcessor) Decode(q *druidPreparedQuery, settings map[string]interface{}, result json.RawMessage) (*druidResponse, error) {
+	return p.decodeResultArrays(q, result)
+}
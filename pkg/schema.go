@@ -0,0 +1,214 @@
+package main
+
+// aggregatorTypes maps Druid aggregator types to the column type of their
+// finalized result.
+var aggregatorTypes = map[string]string{
+	"count":              "int",
+	"longSum":            "int",
+	"longMin":            "int",
+	"longMax":            "int",
+	"longFirst":          "int",
+	"longLast":           "int",
+	"longAny":            "int",
+	"doubleSum":          "float",
+	"doubleMin":          "float",
+	"doubleMax":          "float",
+	"doubleFirst":        "float",
+	"doubleLast":         "float",
+	"doubleAny":          "float",
+	"doubleMean":         "float",
+	"floatSum":           "float",
+	"floatMin":           "float",
+	"floatMax":           "float",
+	"floatFirst":         "float",
+	"floatLast":          "float",
+	"floatAny":           "float",
+	"cardinality":        "float",
+	"hyperUnique":        "float",
+	"javascript":         "float",
+	"stringFirst":        "string",
+	"stringLast":         "string",
+	"stringAny":          "string",
+	"stringFirstFolding": "string",
+	"stringLastFolding":  "string",
+	"histogram":          "json",
+}
+
+// postAggregatorTypes maps Druid post aggregator types to the column type of
+// their result. Field accessors take the type of the field they access.
+var postAggregatorTypes = map[string]string{
+	"arithmetic":             "float",
+	"constant":               "float",
+	"doubleGreatest":         "float",
+	"doubleLeast":            "float",
+	"longGreatest":           "int",
+	"longLeast":              "int",
+	"javascript":             "float",
+	"hyperUniqueCardinality": "float",
+}
+
+// averagerTypes maps the averager types of the Druid moving average query
+// extension to the column type of their result.
+var averagerTypes = map[string]string{
+	"doubleMean":        "float",
+	"doubleMeanNoNulls": "float",
+	"doubleMax":         "float",
+	"doubleMin":         "float",
+	"doubleSum":         "float",
+	"longMean":          "float",
+	"longMeanNoNulls":   "float",
+	"longMax":           "int",
+	"longMin":           "int",
+	"longSum":           "int",
+}
+
+// outputTypes maps Druid value types, as used by dimension specs, virtual
+// columns and expressions output types, to column types.
+var outputTypes = map[string]string{
+	"STRING": "string",
+	"LONG":   "int",
+	"FLOAT":  "float",
+	"DOUBLE": "float",
+}
+
+// queryColumnTypes derives the column types of a native query result from the
+// query itself: its dimensions, virtual columns, aggregations and post
+// aggregations. Columns it can't type are left to value based detection.
+func (ds *druidDatasource) queryColumnTypes(builder map[string]interface{}) map[string]string {
+	types := make(map[string]string)
+	switch builder["queryType"] {
+	case "timeseries", "topN", "groupBy", "movingAverage":
+		types["timestamp"] = "time"
+	case "scan":
+		types["__time"] = "time"
+	}
+	if vcs, ok := builder["virtualColumns"].([]interface{}); ok {
+		for _, vc := range vcs {
+			v, _ := vc.(map[string]interface{})
+			name, _ := v["name"].(string)
+			outputType, _ := v["outputType"].(string)
+			if t, ok := outputTypes[outputType]; ok && name != "" {
+				types[name] = t
+			}
+		}
+	}
+	for _, d := range ds.queryDimensions(builder) {
+		switch dim := d.(type) {
+		case string:
+			types[dim] = "string"
+		case map[string]interface{}:
+			name := ds.dimensionName(dim)
+			t := "string"
+			if outputType, ok := dim["outputType"].(string); ok && outputType != "" {
+				t = outputTypes[outputType]
+			}
+			if name != "" && t != "" {
+				types[name] = t
+			}
+		}
+	}
+	if aggregations, ok := builder["aggregations"].([]interface{}); ok {
+		for _, a := range aggregations {
+			agg, _ := a.(map[string]interface{})
+			name, _ := agg["name"].(string)
+			if t := ds.aggregatorType(agg); t != "" && name != "" {
+				types[name] = t
+			}
+		}
+	}
+	if postAggregations, ok := builder["postAggregations"].([]interface{}); ok {
+		for _, p := range postAggregations {
+			postAgg, _ := p.(map[string]interface{})
+			name, _ := postAgg["name"].(string)
+			if t := ds.postAggregatorType(postAgg, types); t != "" && name != "" {
+				types[name] = t
+			}
+		}
+	}
+	if averagers, ok := builder["averagers"].([]interface{}); ok {
+		for _, a := range averagers {
+			averager, _ := a.(map[string]interface{})
+			typ, _ := averager["type"].(string)
+			name, _ := averager["name"].(string)
+			if t, ok := averagerTypes[typ]; ok && name != "" {
+				types[name] = t
+			}
+		}
+	}
+	if postAveragers, ok := builder["postAveragers"].([]interface{}); ok {
+		for _, p := range postAveragers {
+			postAvg, _ := p.(map[string]interface{})
+			name, _ := postAvg["name"].(string)
+			if t := ds.postAggregatorType(postAvg, types); t != "" && name != "" {
+				types[name] = t
+			}
+		}
+	}
+	return types
+}
+
+func (ds *druidDatasource) aggregatorType(agg map[string]interface{}) string {
+	typ, _ := agg["type"].(string)
+	if typ == "filtered" {
+		inner, _ := agg["aggregator"].(map[string]interface{})
+		return ds.aggregatorType(inner)
+	}
+	return aggregatorTypes[typ]
+}
+
+func (ds *druidDatasource) postAggregatorType(postAgg map[string]interface{}, types map[string]string) string {
+	typ, _ := postAgg["type"].(string)
+	switch typ {
+	case "fieldAccess", "finalizingFieldAccess":
+		fieldName, _ := postAgg["fieldName"].(string)
+		return types[fieldName]
+	case "expression":
+		outputType, _ := postAgg["outputType"].(string)
+		return outputTypes[outputType]
+	}
+	return postAggregatorTypes[typ]
+}
+
+// queryColumnOrder returns the output names of a native query in declaration
+// order: dimensions first, then aggregations, post aggregations and, for moving
+// average queries, averagers and post averagers.
+func (ds *druidDatasource) queryColumnOrder(builder map[string]interface{}) []string {
+	var order []string
+	for _, d := range ds.queryDimensions(builder) {
+		switch dim := d.(type) {
+		case string:
+			order = append(order, dim)
+		case map[string]interface{}:
+			order = append(order, ds.dimensionName(dim))
+		}
+	}
+	for _, components := range []string{"aggregations", "postAggregations", "averagers", "postAveragers"} {
+		list, _ := builder[components].([]interface{})
+		for _, c := range list {
+			component, _ := c.(map[string]interface{})
+			if name, ok := component["name"].(string); ok {
+				order = append(order, name)
+			}
+		}
+	}
+	return order
+}
+
+// queryDimensions returns the dimension specs of a groupBy (dimensions) or
+// topN (dimension) query.
+func (ds *druidDatasource) queryDimensions(builder map[string]interface{}) []interface{} {
+	dimensions, _ := builder["dimensions"].([]interface{})
+	if d, ok := builder["dimension"]; ok {
+		dimensions = append(dimensions, d)
+	}
+	return dimensions
+}
+
+// dimensionName returns the output name of a dimension spec.
+func (ds *druidDatasource) dimensionName(dim map[string]interface{}) string {
+	name, _ := dim["outputName"].(string)
+	if name == "" {
+		name, _ = dim["dimension"].(string)
+	}
+	return name
+}
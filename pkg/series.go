@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// responseColumn is a response column with its converted values.
+type responseColumn struct {
+	druidColumn
+	values []interface{}
+}
+
+// seriesFrames splits a response into time series frames: one frame per
+// numeric column and per combination of string column values, the latter
+// being set as labels of the numeric field. This is the shape Grafana alerting
+// expects to get one alert instance per dimension value. It returns nil if the
+// response has no time column.
+func (ds *druidDatasource) seriesFrames(columns []responseColumn, nullHandling string) []*data.Frame {
+	var timeColumn *responseColumn
+	var dimensions, metrics []*responseColumn
+	for i := range columns {
+		c := &columns[i]
+		switch c.Type {
+		case "time":
+			if timeColumn == nil {
+				timeColumn = c
+			}
+		case "string":
+			dimensions = append(dimensions, c)
+		case "float", "int":
+			metrics = append(metrics, c)
+		}
+	}
+	if timeColumn == nil {
+		return nil
+	}
+	var keys []string
+	series := make(map[string][]int)
+	labels := make(map[string]data.Labels)
+	for ir := range timeColumn.values {
+		l := data.Labels{}
+		for _, d := range dimensions {
+			v, _ := d.values[ir].(string)
+			l[d.Name] = v
+		}
+		key := l.String()
+		if _, ok := series[key]; !ok {
+			keys = append(keys, key)
+			labels[key] = l
+		}
+		series[key] = append(series[key], ir)
+	}
+	var frames []*data.Frame
+	for _, m := range metrics {
+		for _, key := range keys {
+			rows := series[key]
+			sort.SliceStable(rows, func(i, j int) bool {
+				ti, _ := timeColumn.values[rows[i]].(time.Time)
+				tj, _ := timeColumn.values[rows[j]].(time.Time)
+				return ti.Before(tj)
+			})
+			times := make([]interface{}, len(rows))
+			values := make([]interface{}, len(rows))
+			for i, ir := range rows {
+				times[i] = timeColumn.values[ir]
+				values[i] = m.values[ir]
+			}
+			value := ds.newField(m.Name, m.Type, ds.fillNulls(m.Type, values, nullHandling))
+			if len(dimensions) > 0 {
+				value.Labels = labels[key]
+			}
+			name := m.Name
+			if key != "" {
+				name += " {" + key + "}"
+			}
+			frames = append(frames, data.NewFrame(name, ds.newField(timeColumn.Name, timeColumn.Type, times), value))
+		}
+	}
+	return frames
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// simpleGranularities are the Druid simple granularities that can be used to
+// align split boundaries.
+var simpleGranularities = map[string]time.Duration{
+	"none":           time.Millisecond,
+	"second":         time.Second,
+	"minute":         time.Minute,
+	"five_minute":    5 * time.Minute,
+	"ten_minute":     10 * time.Minute,
+	"fifteen_minute": 15 * time.Minute,
+	"thirty_minute":  30 * time.Minute,
+	"hour":           time.Hour,
+	"six_hour":       6 * time.Hour,
+	"eight_hour":     8 * time.Hour,
+	"day":            24 * time.Hour,
+}
+
+var periodRegexp = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+var sqlLimitRegexp = regexp.MustCompile(`(?i)\bLIMIT\s+\d+`)
+
+var (
+	sqlOrderByRegexp        = regexp.MustCompile(`(?is)\bORDER\s+BY\s+(.*?)\s*(?:\bLIMIT\b|\bOFFSET\b|$)`)
+	sqlTimeFloorRegexp      = regexp.MustCompile(`(?i)^TIME_FLOOR\([^()]*\)$`)
+	sqlTimeFloorAliasRegexp = regexp.MustCompile(`(?i)TIME_FLOOR\([^()]*\)\s+AS\s+("[^"]+"|\w+)`)
+	sqlAscendingRegexp      = regexp.MustCompile(`(?i)\s+ASC$`)
+	sqlTimeFloorFirstRegexp = regexp.MustCompile(`(?is)^\s*SELECT\s+TIME_FLOOR\([^()]*\)\s*(?:AS\s+(?:"[^"]+"|\w+)\s*)?,`)
+)
+
+// splitTimeRange returns the aligned sub time ranges a query should be split
+// into when the splitQueries setting asks for it. Only time bucketed queries
+// whose time window follows the Grafana time range can be split, and only when
+// their buckets are at most one day long so that no bucket spans two chunks.
+func (ds *druidDatasource) splitTimeRange(q *druidPreparedQuery, qry backend.DataQuery, settings map[string]interface{}) []backend.TimeRange {
+	n, _ := settings["splitQueries"].(float64)
+	if n < 2 || !q.timeBound {
+		return nil
+	}
+	align, ok := ds.bucketDuration(q)
+	if !ok {
+		return nil
+	}
+	from, to := qry.TimeRange.From, qry.TimeRange.To
+	chunk := to.Sub(from) / time.Duration(n)
+	if chunk < align {
+		return nil
+	}
+	var ranges []backend.TimeRange
+	start := from
+	for i := 1; i < int(n); i++ {
+		stop := ds.floorTime(from.Add(time.Duration(i)*chunk), align)
+		if !stop.After(start) {
+			continue
+		}
+		ranges = append(ranges, backend.TimeRange{From: start, To: stop})
+		start = stop
+	}
+	return append(ranges, backend.TimeRange{From: start, To: to})
+}
+
+// floorTime rounds t down to a multiple of d since the Unix epoch, which is
+// where Druid aligns the buckets of UTC granularities without origin.
+func (ds *druidDatasource) floorTime(t time.Time, d time.Duration) time.Time {
+	offset := time.Duration(t.UnixNano() % int64(d))
+	if offset < 0 {
+		offset += d
+	}
+	return t.Add(-offset)
+}
+
+// timeBucketed tells whether the rows of a query only depend on the data of
+// their own time bucket and come in time order, so that the query gives the
+// same rows when run on parts of its time range. Sql queries must group by
+// $__timeGroup, whose period is timeGroup, in UTC. Limited queries, queries
+// ordered otherwise than by time, grand totals and moving averages whose
+// windows span buckets are not.
+func (ds *druidDatasource) timeBucketed(builder map[string]interface{}, timeGroup string) bool {
+	queryContext, _ := builder["context"].(map[string]interface{})
+	switch builder["queryType"] {
+	case "timeseries":
+		limit, _ := builder["limit"].(float64)
+		return limit == 0 && !ds.flag(builder["descending"]) && !ds.flag(queryContext["grandTotal"])
+	case "topN":
+		return true
+	case "groupBy":
+		limitSpec, _ := builder["limitSpec"].(map[string]interface{})
+		limit, _ := limitSpec["limit"].(float64)
+		columns, _ := limitSpec["columns"].([]interface{})
+		return limit == 0 && len(columns) == 0
+	case "sql":
+		//note: sqlTimeZone shifts the TIME_FLOOR buckets away from the UTC ones chunks are aligned on
+		if tz, _ := queryContext["sqlTimeZone"].(string); !ds.utcTimeZone(tz) {
+			return false
+		}
+		sql, _ := builder["query"].(string)
+		return timeGroup != "" && !sqlLimitRegexp.MatchString(sql) && ds.sqlTimeOrdered(sql)
+	}
+	return false
+}
+
+// flag reads a boolean query property, which query context parameters set as
+// strings.
+func (ds *druidDatasource) flag(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return strings.EqualFold(b, "true")
+	}
+	return false
+}
+
+// sqlTimeOrdered tells whether the ORDER BY clauses of an expanded SQL query,
+// if any, only sort rows by ascending time: __time, a TIME_FLOOR of it, its
+// alias, or the first column when it is one.
+func (ds *druidDatasource) sqlTimeOrdered(sql string) bool {
+	timeColumns := map[string]bool{"__time": true, `"__time"`: true}
+	for _, m := range sqlTimeFloorAliasRegexp.FindAllStringSubmatch(sql, -1) {
+		timeColumns[strings.ToLower(m[1])] = true
+	}
+	if sqlTimeFloorFirstRegexp.MatchString(sql) {
+		timeColumns["1"] = true
+	}
+	for _, m := range sqlOrderByRegexp.FindAllStringSubmatch(sql, -1) {
+		//note: a single item, commas within parentheses are TIME_FLOOR arguments
+		depth := 0
+		for _, c := range m[1] {
+			switch c {
+			case '(':
+				depth++
+			case ')':
+				depth--
+			case ',':
+				if depth == 0 {
+					return false
+				}
+			}
+		}
+		item := sqlAscendingRegexp.ReplaceAllString(strings.TrimSpace(m[1]), "")
+		if !timeColumns[strings.ToLower(item)] && !sqlTimeFloorRegexp.MatchString(item) {
+			return false
+		}
+	}
+	return true
+}
+
+// bucketDuration returns the duration of the time buckets of a time bucketed
+// query, as long as it is fixed, UTC aligned and at most one day long.
+func (ds *druidDatasource) bucketDuration(q *druidPreparedQuery) (time.Duration, bool) {
+	if !q.timeBucketed {
+		return 0, false
+	}
+	var d time.Duration
+	var ok bool
+	if q.Type() == "sql" {
+		d, ok = ds.parsePeriod(q.timeGroup)
+	} else {
+		d, ok = ds.granularityDuration(q.granularity)
+	}
+	return d, ok && d <= 24*time.Hour
+}
+
+// granularityDuration returns the bucket duration of a Druid granularity if it
+// has a fixed one and its buckets are aligned on UTC epoch multiples, i.e. it
+// has no origin and no time zone other than UTC.
+func (ds *druidDatasource) granularityDuration(granularity interface{}) (time.Duration, bool) {
+	switch g := granularity.(type) {
+	case string:
+		d, ok := simpleGranularities[g]
+		return d, ok
+	case map[string]interface{}:
+		if origin, ok := g["origin"]; ok && origin != nil {
+			return 0, false
+		}
+		if tz, _ := g["timeZone"].(string); !ds.utcTimeZone(tz) {
+			return 0, false
+		}
+		switch g["type"] {
+		case "duration":
+			d, ok := g["duration"].(float64)
+			return time.Duration(d) * time.Millisecond, ok && d > 0
+		case "period":
+			p, _ := g["period"].(string)
+			return ds.parsePeriod(p)
+		}
+	}
+	return 0, false
+}
+
+// utcTimeZone tells whether a Druid time zone setting is UTC, the default.
+func (ds *druidDatasource) utcTimeZone(tz string) bool {
+	return tz == "" || tz == "UTC" || tz == "Etc/UTC"
+}
+
+// parsePeriod parses the day and time parts of an ISO 8601 period, e.g. PT5M.
+func (ds *druidDatasource) parsePeriod(period string) (time.Duration, bool) {
+	m := periodRegexp.FindStringSubmatch(period)
+	if m == nil || period == "P" || period == "PT" {
+		return 0, false
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if m[i+1] == "" {
+			continue
+		}
+		v, err := strconv.Atoi(m[i+1])
+		if err != nil {
+			return 0, false
+		}
+		d += time.Duration(v) * unit
+	}
+	return d, d > 0
+}
+
+// executeSplitQuery runs the query once per time range, in parallel within the
+// datasource query limit, and stitches the responses back in time order.
+func (ds *druidDatasource) executeSplitQuery(ctx context.Context, qry backend.DataQuery, ranges []backend.TimeRange, s *druidInstanceSettings) (*druidResponse, error) {
+	interval := ds.effectiveInterval(qry)
+	responses := make([]*druidResponse, len(ranges))
+	errs := make([]error, len(ranges))
+	var wg sync.WaitGroup
+	for i, tr := range ranges {
+		wg.Add(1)
+		go func(i int, tr backend.TimeRange) {
+			defer wg.Done()
+			//note: every chunk but the last one stops where the next one starts
+			responses[i], errs[i] = ds.executeTimeRange(ctx, qry, tr, interval, i < len(ranges)-1, s)
+		}(i, tr)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("query chunk %d/%d (%s): %w", i+1, len(ranges), ds.formatInterval(ranges[i].From.UTC(), ranges[i].To.UTC()), err)
+		}
+	}
+	return ds.stitchResponses(responses), nil
+}
+
+// effectiveInterval is the interval the whole query is bucketed with. Queries
+// run on a part of the time range must keep it to get the same granularity.
+func (ds *druidDatasource) effectiveInterval(qry backend.DataQuery) time.Duration {
+	interval := qry.Interval
+	if qry.MaxDataPoints > 0 {
+		if i := qry.TimeRange.Duration() / time.Duration(qry.MaxDataPoints); i > interval {
+			interval = i
+		}
+	}
+	return interval
+}
+
+// executeTimeRange runs the query on a part of its time range. When
+// exclusiveStop is set, the rows at the stop of the part are left to the part
+// that starts there. Native intervals always exclude their stop, SQL time
+// filters are told to with withExclusiveStop.
+func (ds *druidDatasource) executeTimeRange(ctx context.Context, qry backend.DataQuery, tr backend.TimeRange, interval time.Duration, exclusiveStop bool, s *druidInstanceSettings) (r *druidResponse, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = ds.panicError(p)
+		}
+	}()
+	if exclusiveStop {
+		ctx = ds.withExclusiveStop(ctx)
+	}
+	qry.TimeRange = tr
+	qry.Interval = interval
+	qry.MaxDataPoints = 0
+	q, stg, err := ds.prepareQuery(ctx, qry, s)
+	if err != nil {
+		return nil, err
+	}
+	return ds.executeQuery(ctx, q, s, stg)
+}
+
+type exclusiveStopContextKey struct{}
+
+// withExclusiveStop returns a context telling prepareQuery to expand the SQL
+// time filters without the stop of the query time range.
+func (ds *druidDatasource) withExclusiveStop(ctx context.Context) context.Context {
+	return context.WithValue(ctx, exclusiveStopContextKey{}, true)
+}
+
+func (ds *druidDatasource) exclusiveStop(ctx context.Context) bool {
+	exclusive, _ := ctx.Value(exclusiveStopContextKey{}).(bool)
+	return exclusive
+}
+
+// stitchResponses concatenates the rows of several responses. Columns are
+// matched by name as their order may differ from one response to another.
+func (ds *druidDatasource) stitchResponses(responses []*druidResponse) *druidResponse {
+	r := &druidResponse{}
+	positions := make(map[string]int)
+	for _, resp := range responses {
+		for _, c := range resp.Columns {
+			pos, ok := positions[c.Name]
+			if !ok {
+				positions[c.Name] = len(r.Columns)
+				r.Columns = append(r.Columns, c)
+				continue
+			}
+			if r.Columns[pos].Type == "nil" {
+				r.Columns[pos].Type = c.Type
+			}
+		}
+	}
+	for _, resp := range responses {
+		r.Notices = append(r.Notices, resp.Notices...)
+		for _, row := range resp.Rows {
+			stitched := make([]interface{}, len(r.Columns))
+			for ic, c := range resp.Columns {
+				stitched[positions[c.Name]] = row[ic]
+			}
+			r.Rows = append(r.Rows, stitched)
+		}
+	}
+	return r
+}
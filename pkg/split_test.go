@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// newTestDatasource returns a datasource and the settings of an instance of it
+// querying the broker at url.
+func newTestDatasource(t *testing.T, url string) (*druidDatasource, *druidInstanceSettings) {
+	t.Helper()
+	ds := &druidDatasource{processors: make(map[string]QueryProcessor)}
+	ds.registerQueryProcessors()
+	i, err := newDataSourceInstance(backend.DataSourceInstanceSettings{JSONData: []byte(`{"connection.url":"` + url + `","connection.retryableRetryMax":0}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ds, i.(*druidInstanceSettings)
+}
+
+func TestSplitTimeRange(t *testing.T) {
+	ds, s := newTestDatasource(t, "http://localhost")
+	from := time.Date(2020, 1, 1, 10, 17, 0, 0, time.UTC)
+	qry := backend.DataQuery{
+		TimeRange: backend.TimeRange{From: from, To: from.Add(10 * time.Hour)},
+		JSON:      []byte(`{"builder":{"queryType":"timeseries","granularity":"hour","intervals":["2020-01-01/2020-02-01"]},"settings":{"timeRangeBinding":"replace","splitQueries":3}}`),
+	}
+	q, settings, err := ds.prepareQuery(context.Background(), qry, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges := ds.splitTimeRange(q, qry, settings)
+	if len(ranges) != 3 {
+		t.Fatalf("got %d ranges, want 3", len(ranges))
+	}
+	if !ranges[0].From.Equal(qry.TimeRange.From) || !ranges[2].To.Equal(qry.TimeRange.To) {
+		t.Errorf("ranges %v don't cover the time range", ranges)
+	}
+	for i, r := range ranges {
+		if i > 0 && !r.From.Equal(ranges[i-1].To) {
+			t.Errorf("range %d doesn't start where range %d stops", i, i-1)
+		}
+		if i < len(ranges)-1 && !r.To.Equal(r.To.Truncate(time.Hour)) {
+			t.Errorf("range %d stops at %s, not on an hour bucket", i, r.To)
+		}
+	}
+}
+
+func TestSplitTimeRangeRefused(t *testing.T) {
+	ds, s := newTestDatasource(t, "http://localhost")
+	from := time.Date(2020, 1, 1, 10, 17, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		json string
+	}{
+		{"not bound to the time range", `{"builder":{"queryType":"timeseries","granularity":"hour"},"settings":{"splitQueries":3}}`},
+		{"buckets longer than a day", `{"builder":{"queryType":"timeseries","granularity":"week"},"settings":{"timeRangeBinding":"replace","splitQueries":3}}`},
+		{"time zone", `{"builder":{"queryType":"timeseries","granularity":{"type":"period","period":"P1D","timeZone":"America/New_York"}},"settings":{"timeRangeBinding":"replace","splitQueries":3}}`},
+		{"origin", `{"builder":{"queryType":"timeseries","granularity":{"type":"period","period":"PT1H","origin":"2020-01-01T00:30:00Z"}},"settings":{"timeRangeBinding":"replace","splitQueries":3}}`},
+		{"moving average", `{"builder":{"queryType":"movingAverage","granularity":"hour"},"settings":{"timeRangeBinding":"replace","splitQueries":3}}`},
+		{"limited groupBy", `{"builder":{"queryType":"groupBy","granularity":"hour","limitSpec":{"type":"default","limit":10}},"settings":{"timeRangeBinding":"replace","splitQueries":3}}`},
+		{"passthrough", `{"builder":{"queryType":"someExtension","granularity":"hour"},"settings":{"timeRangeBinding":"replace","splitQueries":3}}`},
+		{"sql without $__timeGroup", `{"builder":{"queryType":"sql","query":"SELECT TIME_FLOOR(__time, 'P1D'), COUNT(*) FROM t WHERE $__timeFilter(__time) GROUP BY 1"},"settings":{"splitQueries":3}}`},
+		{"sql time zone", `{"builder":{"queryType":"sql","query":"SELECT $__timeGroup(__time, '1h'), COUNT(*) FROM t WHERE $__timeFilter(__time) GROUP BY 1"},"settings":{"splitQueries":3,"contextParameters":[{"name":"sqlTimeZone","value":"Europe/Paris"}]}}`},
+		{"limited sql", `{"builder":{"queryType":"sql","query":"SELECT $__timeGroup(__time, '1h'), COUNT(*) FROM t WHERE $__timeFilter(__time) GROUP BY 1 LIMIT 5"},"settings":{"splitQueries":3}}`},
+		{"grand total", `{"builder":{"queryType":"timeseries","granularity":"hour"},"settings":{"timeRangeBinding":"replace","splitQueries":3,"contextParameters":[{"name":"grandTotal","value":"true"}]}}`},
+		{"descending", `{"builder":{"queryType":"timeseries","granularity":"hour","descending":true},"settings":{"timeRangeBinding":"replace","splitQueries":3}}`},
+		{"ordered groupBy", `{"builder":{"queryType":"groupBy","granularity":"hour","limitSpec":{"type":"default","columns":[{"dimension":"count","direction":"descending"}]}},"settings":{"timeRangeBinding":"replace","splitQueries":3}}`},
+		{"sql ordered by time descending", `{"builder":{"queryType":"sql","query":"SELECT $__timeGroup(__time, '1h'), COUNT(*) FROM t WHERE $__timeFilter(__time) GROUP BY 1 ORDER BY 1 DESC"},"settings":{"splitQueries":3}}`},
+		{"sql ordered by value", `{"builder":{"queryType":"sql","query":"SELECT $__timeGroup(__time, '1h') AS \"time\", COUNT(*) AS c FROM t WHERE $__timeFilter(__time) GROUP BY 1 ORDER BY c"},"settings":{"splitQueries":3}}`},
+	}
+	for _, tt := range tests {
+		qry := backend.DataQuery{
+			TimeRange: backend.TimeRange{From: from, To: from.Add(72 * time.Hour)},
+			JSON:      []byte(tt.json),
+		}
+		q, settings, err := ds.prepareQuery(context.Background(), qry, s)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if ranges := ds.splitTimeRange(q, qry, settings); ranges != nil {
+			t.Errorf("%s: query split in %v", tt.name, ranges)
+		}
+	}
+}
+
+func TestSQLTimeOrdered(t *testing.T) {
+	ds := &druidDatasource{}
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{"SELECT TIME_FLOOR(__time, 'PT1H'), COUNT(*) FROM t GROUP BY 1", true},
+		{"SELECT TIME_FLOOR(__time, 'PT1H'), COUNT(*) FROM t GROUP BY 1 ORDER BY 1", true},
+		{"SELECT TIME_FLOOR(__time, 'PT1H') AS \"time\", COUNT(*) FROM t GROUP BY 1 ORDER BY \"time\" ASC", true},
+		{"SELECT TIME_FLOOR(__time, 'PT1H') AS t, COUNT(*) FROM t GROUP BY 1 ORDER BY TIME_FLOOR(__time, 'PT1H')", true},
+		{"SELECT TIME_FLOOR(__time, 'PT1H') AS __time, COUNT(*) FROM t GROUP BY 1 ORDER BY __time", true},
+		{"SELECT TIME_FLOOR(__time, 'PT1H'), COUNT(*) FROM t GROUP BY 1 ORDER BY 1 DESC", false},
+		{"SELECT TIME_FLOOR(__time, 'PT1H') AS t, COUNT(*) AS c FROM t GROUP BY 1 ORDER BY t, c", false},
+		{"SELECT COUNT(*), TIME_FLOOR(__time, 'PT1H') FROM t GROUP BY 2 ORDER BY 1", false},
+	}
+	for _, tt := range tests {
+		if got := ds.sqlTimeOrdered(tt.sql); got != tt.want {
+			t.Errorf("sqlTimeOrdered(%q) = %t, want %t", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTimeRangeSQL(t *testing.T) {
+	ds, s := newTestDatasource(t, "http://localhost")
+	from := time.Date(2020, 1, 1, 10, 17, 0, 0, time.UTC)
+	qry := backend.DataQuery{
+		TimeRange: backend.TimeRange{From: from, To: from.Add(72 * time.Hour)},
+		JSON:      []byte(`{"builder":{"queryType":"sql","query":"SELECT $__timeGroup(__time, '1d'), COUNT(*) FROM t WHERE $__timeFilter(__time) GROUP BY 1"},"settings":{"splitQueries":2}}`),
+	}
+	q, settings, err := ds.prepareQuery(context.Background(), qry, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges := ds.splitTimeRange(q, qry, settings)
+	if len(ranges) != 2 {
+		t.Fatalf("got %d ranges, want 2", len(ranges))
+	}
+	if want := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC); !ranges[0].To.Equal(want) {
+		t.Errorf("first range stops at %s, want %s", ranges[0].To, want)
+	}
+}
+
+var sqlTimeFilterRegexp = regexp.MustCompile(`__time >= TIMESTAMP '([^']+)' AND __time (<=?) TIMESTAMP '([^']+)'`)
+
+// fakeSQLBroker answers SQL queries as if they grouped data rolled up to the
+// hour by hour: one row of count 1 per hour start matched by their time filter.
+type fakeSQLBroker struct{}
+
+func (b fakeSQLBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var q struct {
+		Query string `json:"query"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		http.Error(w, "bad query", http.StatusBadRequest)
+		return
+	}
+	m := sqlTimeFilterRegexp.FindStringSubmatch(q.Query)
+	if m == nil {
+		http.Error(w, "no time filter", http.StatusBadRequest)
+		return
+	}
+	start, _ := time.Parse("2006-01-02 15:04:05.000", m[1])
+	stop, _ := time.Parse("2006-01-02 15:04:05.000", m[3])
+	rows := []string{`["__time","count"]`, `["LONG","LONG"]`, `["TIMESTAMP","BIGINT"]`}
+	t := start.Truncate(time.Hour)
+	if t.Before(start) {
+		t = t.Add(time.Hour)
+	}
+	for ; t.Before(stop) || (m[2] == "<=" && t.Equal(stop)); t = t.Add(time.Hour) {
+		rows = append(rows, fmt.Sprintf(`["%s",1]`, t.Format("2006-01-02T15:04:05.000Z")))
+	}
+	fmt.Fprintf(w, "[%s]", strings.Join(rows, ","))
+}
+
+func TestExecuteSplitQuerySQL(t *testing.T) {
+	srv := httptest.NewServer(fakeSQLBroker{})
+	defer srv.Close()
+	ds, s := newTestDatasource(t, srv.URL)
+	from := time.Date(2020, 1, 1, 10, 17, 0, 0, time.UTC)
+	qry := backend.DataQuery{
+		TimeRange: backend.TimeRange{From: from, To: from.Add(10 * time.Hour)},
+		JSON:      []byte(`{"builder":{"queryType":"sql","query":"SELECT $__timeGroup(__time, '1h') AS __time, COUNT(*) AS \"count\" FROM t WHERE $__timeFilter(__time) GROUP BY 1"},"settings":{"splitQueries":3}}`),
+	}
+	q, settings, err := ds.prepareQuery(context.Background(), qry, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges := ds.splitTimeRange(q, qry, settings)
+	if len(ranges) != 3 {
+		t.Fatalf("got %d ranges, want 3", len(ranges))
+	}
+	r, err := ds.executeSplitQuery(context.Background(), qry, ranges, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//note: the hours the chunks stop at are only returned by the chunks starting there
+	checkHourCounts(t, r, time.Date(2020, 1, 1, 11, 0, 0, 0, time.UTC), 10)
+}
+
+// checkHourCounts checks that a response of fakeSQLBroker holds n consecutive
+// hours from first, each once.
+func checkHourCounts(t *testing.T, r *druidResponse, first time.Time, n int) {
+	t.Helper()
+	if len(r.Rows) != n {
+		t.Fatalf("got %d rows %v, want %d", len(r.Rows), r.Rows, n)
+	}
+	for i, row := range r.Rows {
+		want := first.Add(time.Duration(i) * time.Hour).Format("2006-01-02T15:04:05.000Z")
+		if row[0] != want || row[1] != 1.0 {
+			t.Errorf("row %d = %v, want [%s 1]", i, row, want)
+		}
+	}
+}
+
+func TestFloorTime(t *testing.T) {
+	ds := &druidDatasource{}
+	at := time.Date(2020, 1, 1, 10, 17, 0, 0, time.UTC)
+	tests := []struct {
+		d    time.Duration
+		want time.Time
+	}{
+		{time.Hour, time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{7 * time.Minute, time.Date(2020, 1, 1, 10, 14, 0, 0, time.UTC)},
+		{7 * time.Hour, time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)},
+		{24 * time.Hour, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := ds.floorTime(at, tt.d); !got.Equal(tt.want) {
+			t.Errorf("floorTime(%s, %s) = %s, want %s", at, tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	ds := &druidDatasource{}
+	tests := []struct {
+		period string
+		d      time.Duration
+		ok     bool
+	}{
+		{"PT1H", time.Hour, true},
+		{"P1DT30M", 24*time.Hour + 30*time.Minute, true},
+		{"PT15S", 15 * time.Second, true},
+		{"P1M", 0, false},
+		{"P", 0, false},
+		{"PT", 0, false},
+	}
+	for _, tt := range tests {
+		d, ok := ds.parsePeriod(tt.period)
+		if d != tt.d || ok != tt.ok {
+			t.Errorf("parsePeriod(%q) = %s, %t, want %s, %t", tt.period, d, ok, tt.d, tt.ok)
+		}
+	}
+}
+
+func TestStitchResponses(t *testing.T) {
+	ds := &druidDatasource{}
+	r := ds.stitchResponses([]*druidResponse{
+		{Columns: []druidColumn{{"timestamp", "time"}, {"count", "nil"}}, Rows: [][]interface{}{{"t1", nil}}},
+		{Columns: []druidColumn{{"count", "int"}, {"timestamp", "time"}, {"sum", "float"}}, Rows: [][]interface{}{{2.0, "t2", 1.5}}},
+	})
+	want := []druidColumn{{"timestamp", "time"}, {"count", "int"}, {"sum", "float"}}
+	if len(r.Columns) != len(want) {
+		t.Fatalf("columns = %v, want %v", r.Columns, want)
+	}
+	for i := range want {
+		if r.Columns[i] != want[i] {
+			t.Errorf("column %d = %v, want %v", i, r.Columns[i], want[i])
+		}
+	}
+	if len(r.Rows) != 2 || r.Rows[1][0] != "t2" || r.Rows[1][1] != 2.0 || r.Rows[1][2] != 1.5 || r.Rows[0][2] != nil {
+		t.Errorf("rows = %v", r.Rows)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bitly/go-simplejson"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// newHTTPClient builds the HTTP client the Druid client sends its requests
+// with, from the connection settings of the datasource. Token based
+// authentication is done by the client so that it applies to every request.
+func newHTTPClient(data *simplejson.Json, secureData map[string]string, headers http.Header) (*http.Client, error) {
+	tlsConfig, err := newTLSConfig(data, secureData)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if proxy, err := newProxy(data, secureData); err != nil {
+		return nil, err
+	} else if proxy != nil {
+		transport.Proxy = proxy
+	}
+	client := &http.Client{Transport: transport}
+	tokens, err := newTokenSource(data, secureData, &http.Client{Transport: transport})
+	if err != nil {
+		return nil, err
+	}
+	if tokens != nil {
+		client.Transport = &authTransport{base: transport, tokens: tokens}
+	}
+	if len(headers) > 0 {
+		client.Transport = &headerTransport{base: client.Transport, headers: headers}
+	}
+	return client, nil
+}
+
+// newProxy returns the proxy function of the connection when a proxy URL is
+// set, and nil otherwise, the client then using the proxy of the environment
+// (HTTP_PROXY, HTTPS_PROXY and NO_PROXY). The proxy URL scheme is http, https
+// or socks5. Hosts of the no-proxy list, separated by commas, are reached
+// directly.
+func newProxy(data *simplejson.Json, secureData map[string]string) (func(*http.Request) (*url.URL, error), error) {
+	proxyURL := data.Get("connection.proxyUrl").MustString()
+	if proxyURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL: unsupported scheme %q", u.Scheme)
+	}
+	if user := data.Get("connection.proxyUser").MustString(); user != "" {
+		u.User = url.UserPassword(user, secureData["connection.proxyPassword"])
+	}
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  u.String(),
+		HTTPSProxy: u.String(),
+		NoProxy:    data.Get("connection.noProxy").MustString(),
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}, nil
+}
+
+// isProxyError tells whether a request failed while reaching the proxy or
+// because the proxy refused it, rather than because of Druid.
+func isProxyError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "proxyconnect" {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "socks connect") || strings.Contains(msg, "Proxy Authentication Required")
+}
+
+var headerVariableRegexp = regexp.MustCompile(`\$\{__user\.(login|email|name)\}`)
+
+// customHeaders reads the custom headers of the connection, their names from
+// connection.httpHeaderName1, connection.httpHeaderName2... and their values
+// from the matching secure connection.httpHeaderValue settings. Headers whose
+// value references the Grafana user, e.g. ${__user.login}, are returned apart
+// as they are set per query, see withIdentity.
+func customHeaders(data *simplejson.Json, secureData map[string]string) (http.Header, map[string]string) {
+	static := http.Header{}
+	templated := make(map[string]string)
+	for i := 1; ; i++ {
+		name := data.Get("connection.httpHeaderName" + strconv.Itoa(i)).MustString()
+		if name == "" {
+			break
+		}
+		value := secureData["connection.httpHeaderValue"+strconv.Itoa(i)]
+		if headerVariableRegexp.MatchString(value) {
+			templated[name] = value
+			continue
+		}
+		static.Set(name, value)
+	}
+	return static, templated
+}
+
+// headerTransport sets the custom headers on every request sent to Druid,
+// unless the request already has them.
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	for name, values := range t.headers {
+		if r.Header.Get(name) == "" {
+			r.Header[name] = values
+		}
+	}
+	return t.base.RoundTrip(r)
+}
+
+// newTLSConfig builds the TLS configuration of the connection: a CA bundle to
+// verify the brokers with, a client certificate for mutual TLS, a server name
+// to verify instead of the URL host and a toggle to skip the verification.
+func newTLSConfig(data *simplejson.Json, secureData map[string]string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         data.Get("connection.tlsServerName").MustString(),
+		InsecureSkipVerify: data.Get("connection.tlsSkipVerify").MustBool(),
+	}
+	if caCert := secureData["connection.tlsCaCert"]; caCert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(caCert)) {
+			return nil, errors.New("invalid TLS CA certificate: no PEM certificate found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	clientCert, clientKey := secureData["connection.tlsClientCert"], secureData["connection.tlsClientKey"]
+	if clientCert != "" || clientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(clientCert), []byte(clientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// isCertificateError tells whether a request failed because a certificate was
+// rejected, by the plugin or by the broker.
+func isCertificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var systemRoots x509.SystemRootsError
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalid) || errors.As(err, &hostname) || errors.As(err, &systemRoots) {
+		return true
+	}
+	//note: the alerts sent by the broker, e.g. on a missing client certificate, are not exported
+	return strings.Contains(err.Error(), "tls: ") && strings.Contains(err.Error(), "certificate")
+}
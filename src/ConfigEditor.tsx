@@ -56,8 +56,10 @@ export class ConfigEditor extends PureComponent<Props, State> {
   onConnectionOptionsChange = (connectionSettingsOptions: ConnectionSettingsOptions) => {
     const { options, onOptionsChange } = this.props;
     const { settings, secretSettings, secretSettingsFields } = connectionSettingsOptions;
-    const connectionSettings = this.normalizeData(settings, true, 'connection');
-    const jsonData = { ...options.jsonData, ...connectionSettings };
+    //note: oauthPassThru is read by Grafana itself, so it is not namespaced
+    const { oauthPassThru, ...namespacedSettings } = settings;
+    const connectionSettings = this.normalizeData(namespacedSettings, true, 'connection');
+    const jsonData = { ...options.jsonData, ...connectionSettings, oauthPassThru };
     const connectionSecretSettings = this.normalizeData(secretSettings, true, 'connection');
     const secureJsonData = { ...options.secureJsonData, ...connectionSecretSettings };
     const connectionSecretSettingsFields = this.normalizeData(
@@ -80,7 +82,7 @@ export class ConfigEditor extends PureComponent<Props, State> {
   connectionOptions = (): ConnectionSettingsOptions => {
     const { jsonData, secureJsonData, secureJsonFields } = this.props.options;
     return {
-      settings: this.normalizeData(jsonData, false, 'connection'),
+      settings: { ...this.normalizeData(jsonData, false, 'connection'), oauthPassThru: jsonData.oauthPassThru },
       secretSettings: this.normalizeData(secureJsonData || {}, false, 'connection'),
       secretSettingsFields: this.normalizeData(secureJsonFields || {}, false, 'connection') as KeyValue<boolean>,
     };
@@ -1,15 +1,27 @@
-import React, { FC, ChangeEvent } from 'react';
+import React, { FC } from 'react';
 import { css } from 'emotion';
-import { FieldSet, Field, Switch } from '@grafana/ui';
+import { FieldSet, Field, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
 import { ConnectionSettingsProps } from './types';
-import { DruidBasicAuthSettings } from './';
+import { DruidBasicAuthSettings, DruidBearerAuthSettings, DruidOAuth2Settings } from './';
+
+const authModeSelectOptions: Array<SelectableValue<string>> = [
+  { label: 'None', value: 'none' },
+  { label: 'Basic', value: 'basic', description: 'HTTP Basic authentication' },
+  { label: 'Bearer token', value: 'bearer', description: 'A static token sent as a bearer token' },
+  { label: 'OAuth2', value: 'oauth2', description: 'A token fetched with the OAuth2 client credentials grant' },
+];
 
 export const DruidAuthSettings: FC<ConnectionSettingsProps> = (props: ConnectionSettingsProps) => {
   const { options, onOptionsChange } = props;
   const { settings } = options;
 
-  const onSettingChange = (event: ChangeEvent<HTMLInputElement>) => {
-    settings.basicAuth = event!.currentTarget.checked;
+  //note: datasources saved before the mode selector existed only have the basicAuth toggle
+  const authMode = settings.authMode || (settings.basicAuth ? 'basic' : 'none');
+
+  const onAuthModeChange = (option: SelectableValue<string>) => {
+    settings.authMode = option.value;
+    settings.basicAuth = option.value === 'basic';
     onOptionsChange({ ...options, settings: settings });
   };
 
@@ -21,15 +33,18 @@ export const DruidAuthSettings: FC<ConnectionSettingsProps> = (props: Connection
           width: 300px;
         `}
       >
-        <Field horizontal label="With basic authentication" description="Enable HTTP Basic authentication">
-          <Switch value={settings.basicAuth} onChange={onSettingChange} />
+        <Field horizontal label="Mode" description="How requests are authenticated to Druid">
+          <Select
+            width={20}
+            options={authModeSelectOptions}
+            value={authModeSelectOptions.find((option) => option.value === authMode)}
+            onChange={onAuthModeChange}
+          />
         </Field>
       </FieldSet>
-      {settings.basicAuth && (
-        <>
-          <DruidBasicAuthSettings {...props} />
-        </>
-      )}
+      {authMode === 'basic' && <DruidBasicAuthSettings {...props} />}
+      {authMode === 'bearer' && <DruidBearerAuthSettings {...props} />}
+      {authMode === 'oauth2' && <DruidOAuth2Settings {...props} />}
     </>
   );
 };
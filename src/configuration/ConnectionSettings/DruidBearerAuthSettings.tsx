@@ -0,0 +1,46 @@
+import React, { FC, ChangeEvent } from 'react';
+import { LegacyForms, FieldSet } from '@grafana/ui';
+import { ConnectionSettingsProps } from './types';
+
+const { SecretFormField } = LegacyForms;
+
+export const DruidBearerAuthSettings: FC<ConnectionSettingsProps> = (props: ConnectionSettingsProps) => {
+  const { options, onOptionsChange } = props;
+  const { secretSettings, secretSettingsFields } = options;
+
+  const onSecretSettingChange = (event: ChangeEvent<HTMLInputElement>) => {
+    secretSettings.bearerToken = event.target.value;
+    onOptionsChange({ ...options, secretSettings: secretSettings });
+  };
+
+  const onTokenReset = () => {
+    onOptionsChange({
+      ...options,
+      secretSettingsFields: {
+        ...secretSettingsFields,
+        bearerToken: false,
+      },
+      secretSettings: {
+        ...secretSettings,
+        bearerToken: '',
+      },
+    });
+  };
+
+  return (
+    <FieldSet label="Bearer Token Authentication">
+      <SecretFormField
+        label="Token"
+        name="token"
+        type="password"
+        placeholder="the token"
+        labelWidth={11}
+        inputWidth={20}
+        isConfigured={(secretSettingsFields && secretSettingsFields.bearerToken) as boolean}
+        value={secretSettings.bearerToken || ''}
+        onChange={onSecretSettingChange}
+        onReset={onTokenReset}
+      />
+    </FieldSet>
+  );
+};
@@ -1,12 +1,23 @@
 import React, { FC } from 'react';
-import { DruidHttpSettings, DruidAuthSettings } from './';
+import {
+  DruidHttpSettings,
+  DruidTLSSettings,
+  DruidProxySettings,
+  DruidHeadersSettings,
+  DruidAuthSettings,
+  DruidIdentitySettings,
+} from './';
 import { ConnectionSettingsProps } from './types';
 
 export const DruidConnectionSettings: FC<ConnectionSettingsProps> = (props: ConnectionSettingsProps) => {
   return (
     <>
       <DruidHttpSettings {...props} />
+      <DruidTLSSettings {...props} />
+      <DruidProxySettings {...props} />
+      <DruidHeadersSettings {...props} />
       <DruidAuthSettings {...props} />
+      <DruidIdentitySettings {...props} />
     </>
   );
 };
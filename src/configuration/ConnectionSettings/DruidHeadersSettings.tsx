@@ -0,0 +1,113 @@
+import React, { FC, ChangeEvent } from 'react';
+import { LegacyForms, FieldSet, Button, Icon } from '@grafana/ui';
+import { KeyValue } from '@grafana/data';
+import { ConnectionSettingsProps } from './types';
+
+const { FormField, SecretFormField } = LegacyForms;
+
+//note: headers are stored as httpHeaderName1, httpHeaderName2... with their values
+//in the matching secure httpHeaderValue settings. The backend stops at the first
+//missing name, so only the last header can be removed.
+export const DruidHeadersSettings: FC<ConnectionSettingsProps> = (props: ConnectionSettingsProps) => {
+  const { options, onOptionsChange } = props;
+  const { secretSettingsFields } = options;
+  const settings = options.settings as KeyValue;
+  const secretSettings = options.secretSettings as KeyValue<string>;
+
+  const headers: number[] = [];
+  for (let i = 1; settings['httpHeaderName' + i] !== undefined; i++) {
+    headers.push(i);
+  }
+
+  const onNameChange = (i: number) => (event: ChangeEvent<HTMLInputElement>) => {
+    settings['httpHeaderName' + i] = event.target.value;
+    onOptionsChange({ ...options, settings: settings });
+  };
+
+  const onValueChange = (i: number) => (event: ChangeEvent<HTMLInputElement>) => {
+    secretSettings['httpHeaderValue' + i] = event.target.value;
+    onOptionsChange({ ...options, secretSettings: secretSettings });
+  };
+
+  const onValueReset = (i: number) => () => {
+    onOptionsChange({
+      ...options,
+      secretSettingsFields: {
+        ...secretSettingsFields,
+        ['httpHeaderValue' + i]: false,
+      },
+      secretSettings: {
+        ...secretSettings,
+        ['httpHeaderValue' + i]: '',
+      },
+    });
+  };
+
+  const onAdd = () => {
+    onOptionsChange({
+      ...options,
+      settings: {
+        ...settings,
+        ['httpHeaderName' + (headers.length + 1)]: '',
+      },
+    });
+  };
+
+  const onRemove = () => {
+    const i = headers.length;
+    onOptionsChange({
+      ...options,
+      settings: {
+        ...settings,
+        ['httpHeaderName' + i]: undefined,
+      },
+      secretSettingsFields: {
+        ...secretSettingsFields,
+        ['httpHeaderValue' + i]: false,
+      },
+      secretSettings: {
+        ...secretSettings,
+        ['httpHeaderValue' + i]: '',
+      },
+    });
+  };
+
+  return (
+    <FieldSet label="Custom HTTP Headers">
+      {headers.map((i) => (
+        <div className="gf-form-inline" key={i}>
+          <FormField
+            label="Header"
+            name={'httpHeaderName' + i}
+            type="text"
+            placeholder="the header name. e.g: X-Tenant"
+            labelWidth={11}
+            inputWidth={12}
+            value={settings['httpHeaderName' + i]}
+            onChange={onNameChange(i)}
+          />
+          <SecretFormField
+            label="Value"
+            name={'httpHeaderValue' + i}
+            type="password"
+            placeholder="the value. e.g: ${__user.login}"
+            labelWidth={5}
+            inputWidth={12}
+            isConfigured={(secretSettingsFields && secretSettingsFields['httpHeaderValue' + i]) as boolean}
+            value={secretSettings['httpHeaderValue' + i] || ''}
+            onChange={onValueChange(i)}
+            onReset={onValueReset(i)}
+          />
+          {i === headers.length && (
+            <Button variant="secondary" size="xs" onClick={onRemove}>
+              <Icon name="trash-alt" />
+            </Button>
+          )}
+        </div>
+      ))}
+      <Button variant="secondary" icon="plus" onClick={onAdd}>
+        Add header
+      </Button>
+    </FieldSet>
+  );
+};
@@ -27,6 +27,10 @@ export const DruidHttpSettings: FC<ConnectionSettingsProps> = (props: Connection
         settings.retryableRetryWaitMax = +value;
         break;
       }
+      case 'maxConcurrentQueries': {
+        settings.maxConcurrentQueries = +value;
+        break;
+      }
     }
     onOptionsChange({ ...options, settings: settings });
   };
@@ -73,6 +77,17 @@ export const DruidHttpSettings: FC<ConnectionSettingsProps> = (props: Connection
         value={settings.retryableRetryWaitMax}
         onChange={onSettingChange}
       />
+      <FormField
+        label="Max concurrent queries"
+        name="maxConcurrentQueries"
+        type="number"
+        placeholder="4"
+        tooltip="Queries sent to Druid at once by this datasource. Others wait for a free slot"
+        labelWidth={11}
+        inputWidth={20}
+        value={settings.maxConcurrentQueries}
+        onChange={onSettingChange}
+      />
     </FieldSet>
   );
 };
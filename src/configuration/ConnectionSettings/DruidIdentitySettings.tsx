@@ -0,0 +1,70 @@
+import React, { FC, ChangeEvent } from 'react';
+import { css } from 'emotion';
+import { LegacyForms, FieldSet, Field, Switch, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { ConnectionSettingsProps } from './types';
+
+const { FormField } = LegacyForms;
+
+const forwardUserSelectOptions: Array<SelectableValue<string>> = [
+  { label: 'None', value: '' },
+  { label: 'Login', value: 'login', description: 'Forward the login of the Grafana user' },
+  { label: 'Email', value: 'email', description: 'Forward the email of the Grafana user' },
+];
+
+export const DruidIdentitySettings: FC<ConnectionSettingsProps> = (props: ConnectionSettingsProps) => {
+  const { options, onOptionsChange } = props;
+  const { settings } = options;
+
+  const onSettingChange = (event: ChangeEvent<HTMLInputElement>) => {
+    switch (event.target.name) {
+      case 'oauthPassThru': {
+        settings.oauthPassThru = event!.currentTarget.checked;
+        break;
+      }
+      case 'forwardUserHeader': {
+        settings.forwardUserHeader = event.target.value;
+        break;
+      }
+    }
+    onOptionsChange({ ...options, settings: settings });
+  };
+
+  const onForwardUserChange = (option: SelectableValue<string>) => {
+    settings.forwardUser = option.value;
+    onOptionsChange({ ...options, settings: settings });
+  };
+
+  return (
+    <FieldSet
+      label="Identity"
+      className={css`
+        width: 300px;
+      `}
+    >
+      <Field horizontal label="Forward OAuth identity" description="Forward the OAuth token of the Grafana user">
+        <Switch name="oauthPassThru" value={settings.oauthPassThru} onChange={onSettingChange} />
+      </Field>
+      <Field horizontal label="Forward user" description="Identify the Grafana user to Druid">
+        <Select
+          width={20}
+          options={forwardUserSelectOptions}
+          value={forwardUserSelectOptions.find((option) => option.value === (settings.forwardUser || ''))}
+          onChange={onForwardUserChange}
+        />
+      </Field>
+      {settings.forwardUser && (
+        <FormField
+          label="User header"
+          name="forwardUserHeader"
+          type="text"
+          placeholder="X-Grafana-User"
+          labelWidth={11}
+          inputWidth={20}
+          value={settings.forwardUserHeader}
+          onChange={onSettingChange}
+        />
+      )}
+    </FieldSet>
+  );
+};
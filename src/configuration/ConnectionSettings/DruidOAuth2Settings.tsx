@@ -0,0 +1,95 @@
+import React, { FC, ChangeEvent } from 'react';
+import { LegacyForms, FieldSet } from '@grafana/ui';
+import { ConnectionSettingsProps } from './types';
+
+const { FormField, SecretFormField } = LegacyForms;
+
+export const DruidOAuth2Settings: FC<ConnectionSettingsProps> = (props: ConnectionSettingsProps) => {
+  const { options, onOptionsChange } = props;
+  const { settings, secretSettings, secretSettingsFields } = options;
+
+  const onSettingChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const value = event.target.value;
+    switch (event.target.name) {
+      case 'tokenUrl': {
+        settings.oauth2TokenUrl = value;
+        break;
+      }
+      case 'clientId': {
+        settings.oauth2ClientId = value;
+        break;
+      }
+      case 'scopes': {
+        settings.oauth2Scopes = value;
+        break;
+      }
+    }
+    onOptionsChange({ ...options, settings: settings });
+  };
+
+  const onSecretSettingChange = (event: ChangeEvent<HTMLInputElement>) => {
+    secretSettings.oauth2ClientSecret = event.target.value;
+    onOptionsChange({ ...options, secretSettings: secretSettings });
+  };
+
+  const onClientSecretReset = () => {
+    onOptionsChange({
+      ...options,
+      secretSettingsFields: {
+        ...secretSettingsFields,
+        oauth2ClientSecret: false,
+      },
+      secretSettings: {
+        ...secretSettings,
+        oauth2ClientSecret: '',
+      },
+    });
+  };
+
+  return (
+    <FieldSet label="OAuth2 Client Credentials">
+      <FormField
+        label="Token URL"
+        name="tokenUrl"
+        type="url"
+        placeholder="the token endpoint. e.g: https://auth.example.com/oauth2/token"
+        labelWidth={11}
+        inputWidth={20}
+        value={settings.oauth2TokenUrl}
+        onChange={onSettingChange}
+      />
+      <FormField
+        label="Client ID"
+        name="clientId"
+        type="text"
+        placeholder="the client id"
+        labelWidth={11}
+        inputWidth={20}
+        value={settings.oauth2ClientId}
+        onChange={onSettingChange}
+      />
+      <SecretFormField
+        label="Client secret"
+        name="clientSecret"
+        type="password"
+        placeholder="the client secret"
+        labelWidth={11}
+        inputWidth={20}
+        isConfigured={(secretSettingsFields && secretSettingsFields.oauth2ClientSecret) as boolean}
+        value={secretSettings.oauth2ClientSecret || ''}
+        onChange={onSecretSettingChange}
+        onReset={onClientSecretReset}
+      />
+      <FormField
+        label="Scopes"
+        name="scopes"
+        type="text"
+        placeholder="space or comma separated. e.g: druid:read"
+        labelWidth={11}
+        inputWidth={20}
+        value={settings.oauth2Scopes}
+        onChange={onSettingChange}
+      />
+    </FieldSet>
+  );
+};
@@ -0,0 +1,96 @@
+import React, { FC, ChangeEvent } from 'react';
+import { LegacyForms, FieldSet } from '@grafana/ui';
+import { ConnectionSettingsProps } from './types';
+
+const { FormField, SecretFormField } = LegacyForms;
+
+export const DruidProxySettings: FC<ConnectionSettingsProps> = (props: ConnectionSettingsProps) => {
+  const { options, onOptionsChange } = props;
+  const { settings, secretSettings, secretSettingsFields } = options;
+
+  const onSettingChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const value = event.target.value;
+    switch (event.target.name) {
+      case 'proxyUrl': {
+        settings.proxyUrl = value;
+        break;
+      }
+      case 'proxyUser': {
+        settings.proxyUser = value;
+        break;
+      }
+      case 'noProxy': {
+        settings.noProxy = value;
+        break;
+      }
+    }
+    onOptionsChange({ ...options, settings: settings });
+  };
+
+  const onSecretSettingChange = (event: ChangeEvent<HTMLInputElement>) => {
+    secretSettings.proxyPassword = event.target.value;
+    onOptionsChange({ ...options, secretSettings: secretSettings });
+  };
+
+  const onPasswordReset = () => {
+    onOptionsChange({
+      ...options,
+      secretSettingsFields: {
+        ...secretSettingsFields,
+        proxyPassword: false,
+      },
+      secretSettings: {
+        ...secretSettings,
+        proxyPassword: '',
+      },
+    });
+  };
+
+  return (
+    <FieldSet label="Proxy">
+      <FormField
+        label="URL"
+        name="proxyUrl"
+        type="url"
+        placeholder="http, https or socks5. e.g: socks5://proxy:1080"
+        labelWidth={11}
+        inputWidth={20}
+        tooltip="Defaults to the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables"
+        value={settings.proxyUrl}
+        onChange={onSettingChange}
+      />
+      <FormField
+        label="User"
+        name="proxyUser"
+        type="text"
+        placeholder="the proxy user"
+        labelWidth={11}
+        inputWidth={20}
+        value={settings.proxyUser}
+        onChange={onSettingChange}
+      />
+      <SecretFormField
+        label="Password"
+        name="proxyPassword"
+        type="password"
+        placeholder="the proxy password"
+        labelWidth={11}
+        inputWidth={20}
+        isConfigured={(secretSettingsFields && secretSettingsFields.proxyPassword) as boolean}
+        value={secretSettings.proxyPassword || ''}
+        onChange={onSecretSettingChange}
+        onReset={onPasswordReset}
+      />
+      <FormField
+        label="No proxy"
+        name="noProxy"
+        type="text"
+        placeholder="hosts reached directly. e.g: localhost,.internal"
+        labelWidth={11}
+        inputWidth={20}
+        value={settings.noProxy}
+        onChange={onSettingChange}
+      />
+    </FieldSet>
+  );
+};
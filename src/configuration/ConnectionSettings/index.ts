@@ -2,3 +2,9 @@ export { DruidConnectionSettings } from './DruidConnectionSettings';
 export { DruidHttpSettings } from './DruidHttpSettings';
 export { DruidAuthSettings } from './DruidAuthSettings';
 export { DruidBasicAuthSettings } from './DruidBasicAuthSettings';
+export { DruidBearerAuthSettings } from './DruidBearerAuthSettings';
+export { DruidOAuth2Settings } from './DruidOAuth2Settings';
+export { DruidTLSSettings } from './DruidTLSSettings';
+export { DruidProxySettings } from './DruidProxySettings';
+export { DruidHeadersSettings } from './DruidHeadersSettings';
+export { DruidIdentitySettings } from './DruidIdentitySettings';
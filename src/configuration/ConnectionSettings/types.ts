@@ -5,11 +5,30 @@ export interface ConnectionSettings {
   retryableRetryMax?: number;
   retryableRetryWaitMin?: number;
   retryableRetryWaitMax?: number;
+  maxConcurrentQueries?: number;
   basicAuth?: boolean;
   basicAuthUser?: string;
+  authMode?: string;
+  oauth2TokenUrl?: string;
+  oauth2ClientId?: string;
+  oauth2Scopes?: string;
+  tlsServerName?: string;
+  tlsSkipVerify?: boolean;
+  proxyUrl?: string;
+  proxyUser?: string;
+  noProxy?: string;
+  forwardUser?: string;
+  forwardUserHeader?: string;
+  oauthPassThru?: boolean;
 }
 export interface ConnectionSecretSettings {
   basicAuthPassword?: string;
+  bearerToken?: string;
+  oauth2ClientSecret?: string;
+  tlsCaCert?: string;
+  tlsClientCert?: string;
+  tlsClientKey?: string;
+  proxyPassword?: string;
 }
 export interface ConnectionSettingsOptions {
   settings: ConnectionSettings;
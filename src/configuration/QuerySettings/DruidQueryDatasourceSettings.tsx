@@ -0,0 +1,139 @@
+import React, { PureComponent, ChangeEvent } from 'react';
+import { InlineFieldRow, InlineField, Input } from '@grafana/ui';
+import { QuerySettingsProps } from './types';
+
+export class DruidQueryDatasourceSettings extends PureComponent<QuerySettingsProps> {
+  onTimeZoneChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    settings.timeZone = event!.currentTarget.value;
+    onOptionsChange({ ...options, settings });
+  };
+
+  onCacheTtlChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    const value = event!.currentTarget.value;
+    settings.cacheTtl = value === '' ? undefined : Number(value);
+    onOptionsChange({ ...options, settings });
+  };
+
+  onCacheMaxBytesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    const value = event!.currentTarget.value;
+    settings.cacheMaxBytes = value === '' ? undefined : Number(value);
+    onOptionsChange({ ...options, settings });
+  };
+
+  onIncrementalCacheTtlChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    const value = event!.currentTarget.value;
+    settings.incrementalCacheTtl = value === '' ? undefined : Number(value);
+    onOptionsChange({ ...options, settings });
+  };
+
+  onIncrementalCacheMaxEntriesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    const value = event!.currentTarget.value;
+    settings.incrementalCacheMaxEntries = value === '' ? undefined : Number(value);
+    onOptionsChange({ ...options, settings });
+  };
+
+  onForwardUserContextKeyChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    settings.forwardUserContextKey = event!.currentTarget.value;
+    onOptionsChange({ ...options, settings });
+  };
+
+  render() {
+    const { settings } = this.props.options;
+    return (
+      <div className={'gf-form-group'}>
+        <h3 className="page-heading">Datasource options</h3>
+        <InlineFieldRow>
+          <InlineField
+            label="Time zone"
+            tooltip="Time zone of the auto granularity buckets when the query does not set one"
+            labelWidth={20}
+          >
+            <Input width={30} placeholder="UTC" value={settings.timeZone || ''} onChange={this.onTimeZoneChange} />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Cache TTL"
+            tooltip="How long query responses are cached, in milliseconds. Caching is disabled when empty"
+            labelWidth={20}
+          >
+            <Input
+              type="number"
+              width={30}
+              placeholder="No cache"
+              value={settings.cacheTtl === undefined ? '' : settings.cacheTtl}
+              onChange={this.onCacheTtlChange}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField label="Cache size" tooltip="Maximum size of the cached responses, in bytes" labelWidth={20}>
+            <Input
+              type="number"
+              width={30}
+              placeholder="67108864"
+              value={settings.cacheMaxBytes === undefined ? '' : settings.cacheMaxBytes}
+              onChange={this.onCacheMaxBytesChange}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Incremental cache TTL"
+            tooltip="How long the time buckets of incremental queries are kept, in milliseconds. 0 disables incremental queries"
+            labelWidth={20}
+          >
+            <Input
+              type="number"
+              width={30}
+              placeholder="600000"
+              value={settings.incrementalCacheTtl === undefined ? '' : settings.incrementalCacheTtl}
+              onChange={this.onIncrementalCacheTtlChange}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Incremental cache size"
+            tooltip="Maximum number of incremental queries kept"
+            labelWidth={20}
+          >
+            <Input
+              type="number"
+              width={30}
+              placeholder="256"
+              value={settings.incrementalCacheMaxEntries === undefined ? '' : settings.incrementalCacheMaxEntries}
+              onChange={this.onIncrementalCacheMaxEntriesChange}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="User context key"
+            tooltip="Query context parameter the forwarded Grafana user is set in. Requires a forwarded user"
+            labelWidth={20}
+          >
+            <Input
+              width={30}
+              placeholder="Not set"
+              value={settings.forwardUserContextKey || ''}
+              onChange={this.onForwardUserContextKeyChange}
+            />
+          </InlineField>
+        </InlineFieldRow>
+      </div>
+    );
+  }
+}
@@ -1,7 +1,12 @@
 import React, { FC } from 'react';
-import { DruidQueryContextSettings } from './';
+import { DruidQueryDatasourceSettings, DruidQueryContextSettings } from './';
 import { QuerySettingsProps } from './types';
 
 export const DruidQueryDefaultSettings: FC<QuerySettingsProps> = (props: QuerySettingsProps) => {
-  return <DruidQueryContextSettings {...props} />;
+  return (
+    <>
+      <DruidQueryDatasourceSettings {...props} />
+      <DruidQueryContextSettings {...props} />
+    </>
+  );
 };
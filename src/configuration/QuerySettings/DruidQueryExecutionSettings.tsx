@@ -0,0 +1,105 @@
+import React, { PureComponent, ChangeEvent } from 'react';
+import { InlineFieldRow, InlineField, InlineSwitch, Input } from '@grafana/ui';
+import { QuerySettingsProps } from './types';
+
+export class DruidQueryExecutionSettings extends PureComponent<QuerySettingsProps> {
+  constructor(props: QuerySettingsProps) {
+    super(props);
+
+    const { settings } = this.props.options;
+
+    if (settings.bypassCache === undefined) {
+      settings.bypassCache = false;
+    }
+    if (settings.incrementalCache === undefined) {
+      settings.incrementalCache = false;
+    }
+  }
+
+  onBypassCacheChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    settings.bypassCache = event!.currentTarget.checked;
+    onOptionsChange({ ...options, settings });
+  };
+
+  onIncrementalCacheChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    settings.incrementalCache = event!.currentTarget.checked;
+    onOptionsChange({ ...options, settings });
+  };
+
+  onSplitQueriesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    const value = event!.currentTarget.value;
+    settings.splitQueries = value === '' ? undefined : Number(value);
+    onOptionsChange({ ...options, settings });
+  };
+
+  onScanRowLimitChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    const value = event!.currentTarget.value;
+    settings.scanRowLimit = value === '' ? undefined : Number(value);
+    onOptionsChange({ ...options, settings });
+  };
+
+  render() {
+    const { settings } = this.props.options;
+    return (
+      <div className={'gf-form-group'}>
+        <h3 className="page-heading">Execution options</h3>
+        <InlineFieldRow>
+          <InlineField
+            label="Bypass cache"
+            tooltip="Always send the query to Druid instead of serving it from the datasource cache"
+            labelWidth={20}
+          >
+            <InlineSwitch value={settings.bypassCache} disabled={false} onChange={this.onBypassCacheChange} />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Incremental cache"
+            tooltip="Only fetch the time buckets not already cached on refresh. Requires a time bound, time bucketed query"
+            labelWidth={20}
+          >
+            <InlineSwitch
+              value={settings.incrementalCache}
+              disabled={false}
+              onChange={this.onIncrementalCacheChange}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Split queries"
+            tooltip="Splits the time range in this many chunks queried in parallel. Requires a time bound, time bucketed query"
+            labelWidth={20}
+          >
+            <Input
+              type="number"
+              width={30}
+              placeholder="Not split"
+              value={settings.splitQueries === undefined ? '' : settings.splitQueries}
+              onChange={this.onSplitQueriesChange}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField label="Scan row limit" tooltip="Maximum number of rows returned by scan queries" labelWidth={20}>
+            <Input
+              type="number"
+              width={30}
+              placeholder="100000"
+              value={settings.scanRowLimit === undefined ? '' : settings.scanRowLimit}
+              onChange={this.onScanRowLimitChange}
+            />
+          </InlineField>
+        </InlineFieldRow>
+      </div>
+    );
+  }
+}
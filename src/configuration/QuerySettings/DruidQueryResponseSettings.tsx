@@ -1,5 +1,5 @@
 import React, { PureComponent, ChangeEvent } from 'react';
-import { InlineFieldRow, InlineField, InlineSwitch, Select } from '@grafana/ui';
+import { InlineFieldRow, InlineField, InlineSwitch, Input, Select } from '@grafana/ui';
 import { SelectableValue } from '@grafana/data';
 import { QuerySettingsProps } from './types';
 
@@ -20,6 +20,13 @@ export class DruidQueryResponseSettings extends PureComponent<QuerySettingsProps
   formatSelectOptions: Array<SelectableValue<string>> = [
     { label: 'Long', value: 'long' },
     { label: 'Wide', value: 'wide' },
+    { label: 'Time series', value: 'timeseries' },
+  ];
+
+  nullHandlingSelectOptions: Array<SelectableValue<string>> = [
+    { label: 'Null', value: '', description: 'Keep null values' },
+    { label: 'Zero', value: 'zero', description: 'Replace null values with zero' },
+    { label: 'Previous', value: 'previous', description: 'Replace null values with the previous value' },
   ];
 
   selectFormatOptionByValue = (value?: string): SelectableValue<string> | undefined => {
@@ -33,6 +40,17 @@ export class DruidQueryResponseSettings extends PureComponent<QuerySettingsProps
     return undefined;
   };
 
+  selectNullHandlingOptionByValue = (value?: string): SelectableValue<string> | undefined => {
+    if (undefined === value) {
+      return undefined;
+    }
+    const options = this.nullHandlingSelectOptions.filter((option) => option.value === value);
+    if (options.length > 0) {
+      return options[0];
+    }
+    return undefined;
+  };
+
   onFormatSelectionChange = (option: SelectableValue<string>) => {
     const { options, onOptionsChange } = this.props;
     const { settings } = options;
@@ -47,6 +65,27 @@ export class DruidQueryResponseSettings extends PureComponent<QuerySettingsProps
     onOptionsChange({ ...options, settings: settings });
   };
 
+  onNullHandlingSelectionChange = (option: SelectableValue<string>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    settings.nullHandling = option.value;
+    onOptionsChange({ ...options, settings });
+  };
+
+  onAliasChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    settings.alias = event!.currentTarget.value;
+    onOptionsChange({ ...options, settings });
+  };
+
+  onResultPathChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    settings.resultPath = event!.currentTarget.value;
+    onOptionsChange({ ...options, settings });
+  };
+
   render() {
     const { settings } = this.props.options;
     return (
@@ -70,6 +109,32 @@ export class DruidQueryResponseSettings extends PureComponent<QuerySettingsProps
             <InlineSwitch value={settings.hideEmptyColumns} disabled={false} onChange={this.onHideEmptyColumnsChange} />
           </InlineField>
         </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField label="Null values" tooltip="Changes how null values are returned">
+            <Select
+              width={30}
+              onChange={this.onNullHandlingSelectionChange}
+              options={this.nullHandlingSelectOptions}
+              value={this.selectNullHandlingOptionByValue(settings.nullHandling || '')}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Alias"
+            tooltip="Names the returned series. e.g: {{metric}} {{refId}} {{dimension}}"
+          >
+            <Input width={30} placeholder="{{metric}}" value={settings.alias || ''} onChange={this.onAliasChange} />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Result path"
+            tooltip="Path of the records in the response of queries of a type unknown to the plugin. e.g: $.result.events"
+          >
+            <Input width={30} value={settings.resultPath || ''} onChange={this.onResultPathChange} />
+          </InlineField>
+        </InlineFieldRow>
       </div>
     );
   }
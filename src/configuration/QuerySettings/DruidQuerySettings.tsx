@@ -1,11 +1,18 @@
 import React, { FC } from 'react';
-import { DruidQueryContextSettings, DruidQueryResponseSettings } from './';
+import {
+  DruidQueryContextSettings,
+  DruidQueryTimeSettings,
+  DruidQueryExecutionSettings,
+  DruidQueryResponseSettings,
+} from './';
 import { QuerySettingsProps } from './types';
 
 export const DruidQuerySettings: FC<QuerySettingsProps> = (props: QuerySettingsProps) => {
   return (
     <>
       <DruidQueryContextSettings {...props} />
+      <DruidQueryTimeSettings {...props} />
+      <DruidQueryExecutionSettings {...props} />
       <DruidQueryResponseSettings {...props} />
     </>
   );
@@ -0,0 +1,149 @@
+import React, { PureComponent, ChangeEvent } from 'react';
+import { InlineFieldRow, InlineField, InlineSwitch, Input, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { QuerySettingsProps } from './types';
+
+export class DruidQueryTimeSettings extends PureComponent<QuerySettingsProps> {
+  constructor(props: QuerySettingsProps) {
+    super(props);
+
+    const { settings } = this.props.options;
+
+    if (settings.timeRangeBinding === undefined) {
+      settings.timeRangeBinding = '';
+    }
+    if (settings.autoGranularity === undefined) {
+      settings.autoGranularity = false;
+    }
+  }
+
+  timeRangeBindingSelectOptions: Array<SelectableValue<string>> = [
+    { label: 'None', value: '', description: 'Use the query intervals as they are' },
+    { label: 'Replace', value: 'replace', description: 'Replace the query intervals with the dashboard time range' },
+    {
+      label: 'Intersect',
+      value: 'intersect',
+      description: 'Restrict the query intervals to the dashboard time range',
+    },
+  ];
+
+  selectTimeRangeBindingOptionByValue = (value?: string): SelectableValue<string> | undefined => {
+    if (undefined === value) {
+      return undefined;
+    }
+    const options = this.timeRangeBindingSelectOptions.filter((option) => option.value === value);
+    if (options.length > 0) {
+      return options[0];
+    }
+    return undefined;
+  };
+
+  onTimeRangeBindingSelectionChange = (option: SelectableValue<string>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    settings.timeRangeBinding = option.value;
+    onOptionsChange({ ...options, settings });
+  };
+
+  onAutoGranularityChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    settings.autoGranularity = event!.currentTarget.checked;
+    onOptionsChange({ ...options, settings });
+  };
+
+  onAutoGranularityMinChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    const value = event!.currentTarget.value;
+    settings.autoGranularityMin = value === '' ? undefined : Number(value);
+    onOptionsChange({ ...options, settings });
+  };
+
+  onAutoGranularityMaxChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    const value = event!.currentTarget.value;
+    settings.autoGranularityMax = value === '' ? undefined : Number(value);
+    onOptionsChange({ ...options, settings });
+  };
+
+  onTimeZoneChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { options, onOptionsChange } = this.props;
+    const { settings } = options;
+    settings.timeZone = event!.currentTarget.value;
+    onOptionsChange({ ...options, settings });
+  };
+
+  render() {
+    const { settings } = this.props.options;
+    return (
+      <div className={'gf-form-group'}>
+        <h3 className="page-heading">Time options</h3>
+        <InlineFieldRow>
+          <InlineField
+            label="Time range binding"
+            tooltip="Binds the query intervals to the dashboard time range"
+            labelWidth={20}
+          >
+            <Select
+              width={30}
+              onChange={this.onTimeRangeBindingSelectionChange}
+              options={this.timeRangeBindingSelectOptions}
+              value={this.selectTimeRangeBindingOptionByValue(settings.timeRangeBinding)}
+            />
+          </InlineField>
+        </InlineFieldRow>
+        <InlineFieldRow>
+          <InlineField
+            label="Auto granularity"
+            tooltip="Replaces the query granularity with a period derived from the panel interval"
+            labelWidth={20}
+          >
+            <InlineSwitch value={settings.autoGranularity} disabled={false} onChange={this.onAutoGranularityChange} />
+          </InlineField>
+        </InlineFieldRow>
+        {settings.autoGranularity && (
+          <>
+            <InlineFieldRow>
+              <InlineField label="Min granularity" tooltip="Lower bound, in milliseconds" labelWidth={20}>
+                <Input
+                  type="number"
+                  width={30}
+                  placeholder="No lower bound"
+                  value={settings.autoGranularityMin === undefined ? '' : settings.autoGranularityMin}
+                  onChange={this.onAutoGranularityMinChange}
+                />
+              </InlineField>
+            </InlineFieldRow>
+            <InlineFieldRow>
+              <InlineField label="Max granularity" tooltip="Upper bound, in milliseconds" labelWidth={20}>
+                <Input
+                  type="number"
+                  width={30}
+                  placeholder="No upper bound"
+                  value={settings.autoGranularityMax === undefined ? '' : settings.autoGranularityMax}
+                  onChange={this.onAutoGranularityMaxChange}
+                />
+              </InlineField>
+            </InlineFieldRow>
+            <InlineFieldRow>
+              <InlineField
+                label="Time zone"
+                tooltip="Time zone of the granularity buckets. Defaults to the datasource time zone"
+                labelWidth={20}
+              >
+                <Input
+                  width={30}
+                  placeholder="e.g. Europe/Paris"
+                  value={settings.timeZone || ''}
+                  onChange={this.onTimeZoneChange}
+                />
+              </InlineField>
+            </InlineFieldRow>
+          </>
+        )}
+      </div>
+    );
+  }
+}
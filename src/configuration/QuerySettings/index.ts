@@ -2,3 +2,6 @@ export { DruidQueryDefaultSettings } from './DruidQueryDefaultSettings';
 export { DruidQuerySettings } from './DruidQuerySettings';
 export { DruidQueryContextSettings } from './DruidQueryContextSettings';
 export { DruidQueryResponseSettings } from './DruidQueryResponseSettings';
+export { DruidQueryTimeSettings } from './DruidQueryTimeSettings';
+export { DruidQueryExecutionSettings } from './DruidQueryExecutionSettings';
+export { DruidQueryDatasourceSettings } from './DruidQueryDatasourceSettings';
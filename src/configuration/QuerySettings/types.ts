@@ -7,6 +7,23 @@ export interface QuerySettings {
   format?: string;
   contextParameters?: QueryContextParameter[];
   hideEmptyColumns?: boolean;
+  nullHandling?: string;
+  alias?: string;
+  resultPath?: string;
+  timeRangeBinding?: string;
+  autoGranularity?: boolean;
+  autoGranularityMin?: number;
+  autoGranularityMax?: number;
+  timeZone?: string;
+  bypassCache?: boolean;
+  cacheTtl?: number;
+  cacheMaxBytes?: number;
+  incrementalCache?: boolean;
+  incrementalCacheTtl?: number;
+  incrementalCacheMaxEntries?: number;
+  splitQueries?: number;
+  scanRowLimit?: number;
+  forwardUserContextKey?: string;
 }
 export interface QuerySettingsOptions {
   settings: QuerySettings;
@@ -12,6 +12,7 @@ export interface DruidQuery extends DataQuery {
 export interface DruidSettings extends DataSourceJsonData {
   connection?: ConnectionSettings;
   query?: QuerySettings;
+  oauthPassThru?: boolean;
 }
 
 export interface DruidSecureSettings {}